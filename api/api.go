@@ -7,14 +7,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/bluele/gcache"
 	"github.com/go-chi/chi/middleware"
-	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/supabase/supabase-admin-api/api/config_history"
+	"github.com/supabase/supabase-admin-api/api/diagnostics"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/log_redaction"
+	"github.com/supabase/supabase-admin-api/api/maintenance"
 	metrics "github.com/supabase/supabase-admin-api/api/metrics_endpoint"
 	"github.com/supabase/supabase-admin-api/api/network_bans"
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"github.com/supabase/supabase-admin-api/api/restart_limits"
+	"github.com/supabase/supabase-admin-api/api/units"
 	"github.com/supabase/supabase-admin-api/monitors"
 
 	"github.com/go-chi/chi"
@@ -29,18 +38,30 @@ const (
 
 // Config is the main API config
 type Config struct {
-	Host                           string                        `yaml:"host" default:"localhost"`
-	Port                           int                           `yaml:"port" default:"8085"`
-	JwtSecret                      string                        `yaml:"jwt_secret" required:"true"`
-	MetricCollectors               []string                      `yaml:"metric_collectors" required:"true"`
-	GotrueHealthEndpoint           string                        `yaml:"gotrue_health_endpoint" required:"false"`
-	PostgrestEndpoint              string                        `yaml:"postgrest_endpoint" required:"false"`
-	PgBouncerEndpoints             []string                      `yaml:"pgbouncer_endpoints" required:"false"`
-	RealtimeServiceName            string                        `yaml:"realtime_service_name" required:"false"`
-	UpstreamMetricsSources         []metrics.MetricsSourceConfig `yaml:"upstream_metrics_sources" required:"true"`
-	NodeExporterAdditionalArgs     []string                      `yaml:"node_exporter_additional_args" required:"false"`
-	UpstreamMetricsRefreshDuration string                        `yaml:"upstream_metrics_refresh_duration"`
-	Fail2banSocket                 string                        `yaml:"fail2ban_socket" required:"true"`
+	Host                           string                          `yaml:"host" default:"localhost"`
+	Port                           int                             `yaml:"port" default:"8085"`
+	JwtSecret                      string                          `yaml:"jwt_secret" required:"true"`
+	MetricCollectors               []string                        `yaml:"metric_collectors" required:"true"`
+	GotrueHealthEndpoint           string                          `yaml:"gotrue_health_endpoint" required:"false"`
+	PostgrestEndpoint              string                          `yaml:"postgrest_endpoint" required:"false"`
+	PgBouncerEndpoints             []string                        `yaml:"pgbouncer_endpoints" required:"false"`
+	RealtimeServiceName            string                          `yaml:"realtime_service_name" required:"false"`
+	UpstreamMetricsSources         []metrics.MetricsSourceConfig   `yaml:"upstream_metrics_sources" required:"true"`
+	NodeExporterAdditionalArgs     []string                        `yaml:"node_exporter_additional_args" required:"false"`
+	UpstreamMetricsRefreshDuration string                          `yaml:"upstream_metrics_refresh_duration"`
+	UpstreamMetricsTimeout         string                          `yaml:"upstream_metrics_timeout" required:"false"`
+	Fail2banSocket                 string                          `yaml:"fail2ban_socket" required:"true"`
+	ConfigHistoryDir               string                          `yaml:"config_history_dir" required:"false"`
+	ConfigHistoryRetention         int                             `yaml:"config_history_retention" required:"false"`
+	ApplyTimeout                   string                          `yaml:"apply_timeout" required:"false"`
+	JobsDir                        string                          `yaml:"jobs_dir" required:"false"`
+	JobsRetention                  int                             `yaml:"jobs_retention" required:"false"`
+	MaintenanceDir                 string                          `yaml:"maintenance_dir" required:"false"`
+	MaxLogFollowers                int                             `yaml:"max_log_followers" required:"false"`
+	Applications                   map[string]registry.Application `yaml:"applications" required:"false"`
+	RestartLimits                  restart_limits.Config           `yaml:"restart_limits" required:"false"`
+	LogRedaction                   log_redaction.Config            `yaml:"log_redaction" required:"false"`
+	Diagnostics                    diagnostics.Config              `yaml:"diagnostics" required:"false"`
 
 	// supply to enable TLS termination
 	KeyPath  string `yaml:"key_path" required:"false"`
@@ -55,7 +76,6 @@ const DefaultRealtimeServiceName = "realtime"
 
 func (c *Config) GetMetricsSources() []metrics.MetricsSource {
 	logger := logrus.New()
-	var parser expfmt.TextParser
 	sources := make([]metrics.MetricsSource, 0)
 	for _, config := range c.UpstreamMetricsSources {
 		timeoutS := config.SourceTimeout
@@ -74,12 +94,16 @@ func (c *Config) GetMetricsSources() []metrics.MetricsSource {
 				},
 			},
 		}
+		relabeler, err := metrics.NewRelabeler(config.MetricRelabelConfigs)
+		if err != nil {
+			logger.Panicf("failed to parse upstream metric source relabeling: %+v", err)
+		}
 		sourceLogger := logger.WithField("source", config.Name)
 		source := metrics.MetricsSource{
 			Config:     config,
 			HttpClient: &client,
 			Logger:     sourceLogger,
-			Parser:     &parser,
+			Relabeler:  relabeler,
 		}
 		logger.Infof("Creating source for %+v", config)
 		sources = append(sources, source)
@@ -89,11 +113,27 @@ func (c *Config) GetMetricsSources() []metrics.MetricsSource {
 
 // API is the main REST API
 type API struct {
-	handler     http.Handler
-	config      *Config
-	version     string
-	networkBans *network_bans.Fail2Ban
-	monitoring  *monitors.MonitorSet
+	handler       http.Handler
+	config        *Config
+	version       string
+	networkBans   *network_bans.Fail2Ban
+	monitoring    *monitors.MonitorSet
+	configHistory *config_history.Store
+	applyTimeout  time.Duration
+	// unitSettlePeriod is how long a restarted unit has to stay active before it counts as up
+	unitSettlePeriod time.Duration
+	applications     *registry.Registry
+	configLocks      sync.Map
+	jobs             *jobs.Manager
+	units            units.UnitManager
+	restartLimits    *restart_limits.Limiter
+	maintenance      *maintenance.Scheduler
+	logFollowers     *logFollowers
+	logRedaction     *log_redaction.Redactor
+	// metricsGatherer is what /metrics serves, included in diagnostics bundles
+	metricsGatherer   prometheus.Gatherer
+	diagnosticsLimits diagnostics.Limits
+	diagnosticsLock   sync.Mutex
 }
 
 // ListenAndServe starts the REST API
@@ -104,16 +144,20 @@ func (a *API) ListenAndServe(hostAndPort string, keyPath string, certPath string
 		Handler: a.handler,
 	}
 
+	server.RegisterOnShutdown(a.logFollowers.Stop)
+
 	done := make(chan struct{})
 	defer close(done)
 
 	a.monitoring.StartMonitoring()
+	go a.maintenance.Start()
 
 	go func() {
 		waitForTermination(log, done)
 		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 		defer cancel()
 		a.monitoring.StopMonitoring()
+		a.maintenance.Stop()
 		if err := server.Shutdown(ctx); err != nil {
 			log.WithError(err).Error("Error shutting down server")
 		}
@@ -148,19 +192,128 @@ func waitForTermination(log logrus.FieldLogger, done <-chan struct{}) {
 func NewAPIWithVersion(config *Config, version string) *API {
 	fail2ban := network_bans.Fail2Ban{Fail2banSocket: config.Fail2banSocket}
 
-	monitorSet, err := monitors.NewMonitorSet(config.Monitoring)
+	if config.ConfigHistoryDir == "" {
+		config.ConfigHistoryDir = DefaultConfigHistoryDir
+	}
+	if config.ConfigHistoryRetention == 0 {
+		config.ConfigHistoryRetention = DefaultConfigHistoryRetention
+	}
+	configHistory := config_history.NewStore(config.ConfigHistoryDir, config.ConfigHistoryRetention)
+
+	if config.ApplyTimeout == "" {
+		config.ApplyTimeout = DefaultApplyTimeout
+	}
+	applyTimeout, err := time.ParseDuration(config.ApplyTimeout)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to parse apply timeout")
+	}
+
+	if config.JobsDir == "" {
+		config.JobsDir = DefaultJobsDir
+	}
+	if config.JobsRetention == 0 {
+		config.JobsRetention = DefaultJobsRetention
+	}
+	jobManager, err := jobs.NewManager(config.JobsDir, config.JobsRetention)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to load jobs")
+	}
+
+	restartLimits, err := restart_limits.NewLimiter(config.RestartLimits)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to configure restart limits")
+	}
+
+	logRedaction, err := log_redaction.New(config.LogRedaction)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to configure log redaction")
+	}
+
+	diagnosticsLimits, err := config.Diagnostics.Parse()
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to configure diagnostics")
+	}
+
+	kongErrorLog := config.Monitoring.KongLogs.ErrorLog
+	if kongErrorLog == "" {
+		kongErrorLog = monitors.DefaultKongErrorLog
+	}
+	applications, err := registry.New(registry.Defaults(registry.DefaultsConfig{
+		RealtimeServiceName:  config.RealtimeServiceName,
+		GotrueHealthEndpoint: config.GotrueHealthEndpoint,
+		PostgrestEndpoint:    config.PostgrestEndpoint,
+		KongErrorLog:         kongErrorLog,
+	}), config.Applications)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to configure managed applications")
+	}
+
+	api := &API{
+		config:            config,
+		version:           version,
+		networkBans:       &fail2ban,
+		configHistory:     configHistory,
+		applyTimeout:      applyTimeout,
+		unitSettlePeriod:  defaultUnitSettlePeriod,
+		applications:      applications,
+		jobs:              jobManager,
+		units:             units.NewSystemd(),
+		restartLimits:     restartLimits,
+		logRedaction:      logRedaction,
+		diagnosticsLimits: diagnosticsLimits,
+	}
+
+	if config.MaxLogFollowers == 0 {
+		config.MaxLogFollowers = DefaultMaxLogFollowers
+	}
+	api.logFollowers = newLogFollowers(config.MaxLogFollowers)
+
+	if config.MaintenanceDir == "" {
+		config.MaintenanceDir = DefaultMaintenanceDir
+	}
+	api.maintenance, err = maintenance.NewScheduler(config.MaintenanceDir, api.runMaintenance)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to load the maintenance schedule")
+	}
+
+	managedConfigs := make([]monitors.ManagedConfig, 0)
+	for _, app := range applications.All(registry.HasConfig) {
+		managedConfigs = append(managedConfigs, monitors.ManagedConfig{Application: app.Name, Path: app.Config.Path})
+	}
+	kongConfigPath := ""
+	if kong, ok := applications.Get(kongApplication); ok && registry.HasConfig(kong) {
+		kongConfigPath = kong.Config.Path
+	}
+	api.monitoring, err = monitors.NewMonitorSet(config.Monitoring, managedConfigs, configHistory, api.lockConfig, kongConfigPath)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to configure monitoring")
 	}
 
-	api := &API{config: config, version: version, networkBans: &fail2ban, monitoring: monitorSet}
 	nodeMetrics, err := NewMetrics(config.MetricCollectors, config.GotrueHealthEndpoint, config.PostgrestEndpoint, config.PgBouncerEndpoints, config.NodeExporterAdditionalArgs)
 	if err != nil {
 		panic(fmt.Sprintf("Couldn't initialize metrics: %+v", err))
 	}
+	if err := nodeMetrics.registry.Register(api.monitoring.ConfigDrift()); err != nil {
+		panic(fmt.Sprintf("Couldn't initialize metrics: %+v", err))
+	}
+	if err := nodeMetrics.registry.Register(api.monitoring.KongLogs()); err != nil {
+		panic(fmt.Sprintf("Couldn't initialize metrics: %+v", err))
+	}
+	if err := nodeMetrics.registry.Register(logRedaction); err != nil {
+		panic(fmt.Sprintf("Couldn't initialize metrics: %+v", err))
+	}
+	api.metricsGatherer = nodeMetrics.registry
 
+	if config.UpstreamMetricsTimeout == "" {
+		config.UpstreamMetricsTimeout = DefaultTimeout
+	}
+	upstreamTimeout, err := time.ParseDuration(config.UpstreamMetricsTimeout)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to parse upstream metrics timeout")
+	}
 	projectMetrics := metrics.Metrics{
 		Sources: config.GetMetricsSources(),
+		Timeout: upstreamTimeout,
 	}
 	duration, err := time.ParseDuration(config.UpstreamMetricsRefreshDuration)
 	if err != nil {
@@ -202,22 +355,64 @@ func NewAPIWithVersion(config *Config, version string) *API {
 			})
 
 			r.Route("/service", func(r chi.Router) {
-				// applications are kong, pglisten, postgrest, goauth, realtime, adminapi, all
+				// applications are any registered application with a unit, or all
 				r.Route("/restart", func(r chi.Router) {
 					r.Method("GET", "/", ErrorHandlingWrapper(api.HandleLifecycleCommand))
 					r.Method("GET", "/{application}", ErrorHandlingWrapper(api.HandleLifecycleCommand))
 				})
+				r.Route("/restart-limits", func(r chi.Router) {
+					r.Method("GET", "/", ErrorHandlingWrapper(api.GetRestartLimits))
+					r.Method("POST", "/{application}/reset", ErrorHandlingWrapper(api.ResetRestartBreaker))
+				})
+				r.Route("/status", func(r chi.Router) {
+					r.Method("GET", "/", ErrorHandlingWrapper(api.GetServiceStatuses))
+					r.With(api.ApplicationResolvingHandler(registry.HasUnit)).Method("GET", "/{application}", ErrorHandlingWrapper(api.GetServiceStatus))
+				})
 			})
 
-			// applications are kong, pglisten, postgrest, goauth, realtime, adminapi
+			r.With(api.SecretRevealingHandler).Method("GET", "/config/drift", ErrorHandlingWrapper(api.GetConfigDrift))
+			r.Method("POST", "/config/batch", ErrorHandlingWrapper(api.SetBatchFileContents))
+
+			// applications are any registered application with a config file
 			r.Route("/config/{application}", func(r chi.Router) {
+				r.Use(api.ApplicationResolvingHandler(registry.HasConfig))
+				r.Use(api.SecretRevealingHandler)
 				r.Method("GET", "/", ErrorHandlingWrapper(api.GetFileContents))
 				r.Method("POST", "/", ErrorHandlingWrapper(api.SetFileContents))
+				r.Method("PATCH", "/", ErrorHandlingWrapper(api.PatchFileContents))
+				r.Method("POST", "/diff", ErrorHandlingWrapper(api.PreviewFileContents))
+				r.Method("GET", "/history", ErrorHandlingWrapper(api.GetConfigHistory))
+				r.Method("GET", "/history/{rev:[0-9]+}", ErrorHandlingWrapper(api.GetConfigRevision))
+				r.Method("GET", "/diff/{from:[0-9]+}/{to:[0-9]+}", ErrorHandlingWrapper(api.DiffConfigRevisions))
+				r.Method("POST", "/rollback/{rev:[0-9]+}", ErrorHandlingWrapper(api.RollbackConfig))
+			})
+
+			// applications are any registered application with a log source
+			r.Route("/logs/{application}", func(r chi.Router) {
+				r.Use(api.ApplicationResolvingHandler(registry.HasLogs))
+				r.Use(api.SecretRevealingHandler)
+				r.With(api.ApplicationResolvingHandler(registry.HasJournal)).Method("GET", "/", ErrorHandlingWrapper(api.QueryLogs))
+				r.With(api.ApplicationResolvingHandler(registry.HasJournal)).Method("GET", "/follow", ErrorHandlingWrapper(api.FollowLogs))
+				r.With(api.ApplicationResolvingHandler(registry.HasLogFiles)).Method("GET", "/server", ErrorHandlingWrapper(api.QueryServerLogs))
+				r.With(api.ApplicationResolvingHandler(registry.HasLogFiles)).Method("GET", "/errors", ErrorHandlingWrapper(api.GetServerLogErrors))
+				r.Method("GET", "/{type}/{n:[0-9]*}", ErrorHandlingWrapper(api.GetLogContents))
 			})
 
-			// applications are kong, pglisten, postgrest, goauth, realtime
-			r.Route("/logs/{application}/{type}/{n:[0-9]*}", func(r chi.Router) {
-				r.Method("GET", "/", ErrorHandlingWrapper(api.GetLogContents))
+			r.Route("/maintenance/schedule", func(r chi.Router) {
+				r.Use(api.SecretRevealingHandler)
+				r.Method("GET", "/", ErrorHandlingWrapper(api.ListMaintenance))
+				r.Method("POST", "/", ErrorHandlingWrapper(api.ScheduleMaintenance))
+				r.Method("GET", "/{id}", ErrorHandlingWrapper(api.GetMaintenance))
+				r.Method("DELETE", "/{id}", ErrorHandlingWrapper(api.CancelMaintenance))
+			})
+
+			r.Method("GET", "/diagnostics/bundle", ErrorHandlingWrapper(api.GetDiagnosticsBundle))
+			r.Method("GET", "/kong/errors", ErrorHandlingWrapper(api.GetKongErrors))
+
+			r.Route("/jobs", func(r chi.Router) {
+				r.Method("GET", "/", ErrorHandlingWrapper(api.ListJobs))
+				r.Method("GET", "/{id}", ErrorHandlingWrapper(api.GetJob))
+				r.Method("POST", "/{id}/cancel", ErrorHandlingWrapper(api.CancelJob))
 			})
 
 			r.Route("/cert", func(r chi.Router) {
@@ -244,8 +439,9 @@ func NewAPIWithVersion(config *Config, version string) *API {
 	})
 
 	corsHandler := cors.New(cors.Options{
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", audHeaderName},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", audHeaderName},
+		ExposedHeaders:   []string{"ETag", "Retry-After", "X-Redacted"},
 		AllowCredentials: true,
 	})
 
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+const applicationContextKey = contextKey("application")
+
+// ApplicationResolvingHandler looks up the {application} URL parameter in the registry, answering
+// with a 404 unless it names a registered application with the given capability
+func (a *API) ApplicationResolvingHandler(capability registry.Capability) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			name := chi.URLParam(r, "application")
+			app, ok := a.applications.Get(name)
+			if !ok || !capability(app) {
+				if err := sendJSON(w, http.StatusNotFound, fmt.Sprintf("unknown application %q", name)); err != nil {
+					handleError(err, w, r)
+				}
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), applicationContextKey, app)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// getApplication returns the application resolved by ApplicationResolvingHandler
+func getApplication(r *http.Request) *registry.Application {
+	app, _ := r.Context().Value(applicationContextKey).(*registry.Application)
+	return app
+}
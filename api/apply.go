@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"github.com/supabase/supabase-admin-api/api/units"
+)
+
+const DefaultApplyTimeout = "60s"
+
+// defaultUnitSettlePeriod is how long a unit has to stay active after a restart before it counts as up
+const defaultUnitSettlePeriod = 3 * time.Second
+
+// unitActivePollInterval is how often the state of a restarted unit is checked
+const unitActivePollInterval = 500 * time.Millisecond
+
+const automaticRollbackAuthor = "adminapi:automatic-rollback"
+
+type ApplyOutcome = string
+
+const (
+	Applied        ApplyOutcome = "applied"
+	RolledBack     ApplyOutcome = "rolled_back"
+	RollbackFailed ApplyOutcome = "rollback_failed"
+)
+
+// ApplyStep records a single action taken while applying a config change
+type ApplyStep struct {
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// ApplySteps records the actions taken while applying a config change, in order
+type ApplySteps []ApplyStep
+
+// ApplyReport describes the full sequence of a guarded config apply and how it ended
+type ApplyReport struct {
+	Application  string       `json:"application"`
+	Unit         string       `json:"unit"`
+	Outcome      ApplyOutcome `json:"outcome"`
+	BytesWritten int          `json:"bytes_written"`
+	Steps        ApplySteps   `json:"steps"`
+}
+
+func (steps *ApplySteps) run(action string, target string, fn func() error) error {
+	started := time.Now()
+	err := fn()
+	step := ApplyStep{
+		Action:   action,
+		Target:   target,
+		Success:  err == nil,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	*steps = append(*steps, step)
+	return err
+}
+
+// canGuardApply reports whether a restart of the application can be watched to completion; the
+// admin API cannot wait on its own restart
+func canGuardApply(app *registry.Application) bool {
+	return app.Restartable() && !app.Async
+}
+
+// checkConfigRestart checks the restart budget of the unit that applying a config change to app
+// restarts, unless the unit is only reloaded
+func (a *API) checkConfigRestart(app *registry.Application) error {
+	if !app.Restartable() {
+		return nil
+	}
+	if canGuardApply(app) {
+		return a.checkRestart(app.Unit, app.Reload)
+	}
+	return a.checkRestart(app.Unit, Restart)
+}
+
+// guardedApply writes a config file, restarts its service and waits for the service to come back
+// healthy, restoring the previous config and restarting again if it does not, or if ctx is done
+// first. Nothing is written when the restart budget wouldn't allow the restart. It runs until the
+// outcome is known, which with a rollback takes up to twice the apply timeout
+func (a *API) guardedApply(ctx context.Context, app *registry.Application, contents []byte, author string) (*ApplyReport, error) {
+	if err := a.checkConfigRestart(app); err != nil {
+		return nil, err
+	}
+	configFilePath := app.Config.Path
+	report := &ApplyReport{Application: app.Name, Unit: app.Unit, Steps: make(ApplySteps, 0)}
+
+	previous, err := os.ReadFile(configFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	hadPrevious := err == nil
+
+	err = report.Steps.run("write", configFilePath, func() error {
+		var err error
+		report.BytesWritten, err = a.writeConfigFile(app, contents, author)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if a.restartAndVerify(ctx, &report.Steps, app, false) == nil {
+		report.Outcome = Applied
+		return report, nil
+	}
+
+	logrus.WithField("application", app.Name).Warn("service failed to come back after config change, restoring previous config")
+	err = report.Steps.run("restore", configFilePath, func() error {
+		return a.restoreConfigFile(app, previous, hadPrevious)
+	})
+	if err == nil {
+		// the previous config is brought back up even when the apply was given up on
+		err = a.restartAndVerify(context.Background(), &report.Steps, app, true)
+	}
+	if err != nil {
+		report.Outcome = RollbackFailed
+	} else {
+		report.Outcome = RolledBack
+	}
+	return report, nil
+}
+
+// restoreConfigFile puts back the version of a config file from before a failed apply, removing
+// the file if there was none; the backup still holds that same version, rather than the failed one
+func (a *API) restoreConfigFile(app *registry.Application, previous []byte, existed bool) error {
+	if !existed {
+		return os.Remove(app.Config.Path)
+	}
+	_, err := a.replaceConfigFile(app, previous, automaticRollbackAuthor)
+	return err
+}
+
+// sendGuardedApply runs a guarded apply and reports its outcome, answering with a 500 if the new
+// config had to be rolled back. The apply isn't tied to the request, so a client going away doesn't
+// roll it back half way
+func (a *API) sendGuardedApply(w http.ResponseWriter, app *registry.Application, contents []byte, author string) error {
+	report, err := a.guardedApply(context.Background(), app, contents, author)
+	if err != nil {
+		return sendRestartError(w, err)
+	}
+	setConfigETag(w, app)
+	if report.Outcome != Applied {
+		return sendJSON(w, http.StatusInternalServerError, report)
+	}
+	return sendJSON(w, http.StatusOK, report)
+}
+
+// restartAndVerify restarts or reloads an application's unit and waits for it to come back healthy.
+// Restarts are taken from the unit's restart budget, except when restoring a previous config, which
+// has to go through whatever the budget says; either way, their outcome counts towards the breaker,
+// unless ctx was done before it was known
+func (a *API) restartAndVerify(ctx context.Context, steps *ApplySteps, app *registry.Application, restoring bool) error {
+	if app.Reload != Restart {
+		return a.reloadAndVerify(ctx, steps, app)
+	}
+	if !restoring {
+		if err := steps.run("restart-budget", app.Unit, func() error {
+			return a.takeRestart(app.Unit)
+		}); err != nil {
+			return err
+		}
+	}
+	err := a.reloadAndVerify(ctx, steps, app)
+	if err == nil || ctx.Err() == nil {
+		a.restartLimits.Record(app.Unit, err == nil)
+	}
+	return err
+}
+
+// reloadAndVerify runs an application's reload strategy and waits for its unit to come back
+// healthy, for at most the apply timeout
+func (a *API) reloadAndVerify(ctx context.Context, steps *ApplySteps, app *registry.Application) error {
+	ctx, cancel := context.WithTimeout(ctx, a.applyTimeout)
+	defer cancel()
+
+	if err := steps.run("daemon-reload", "systemd", func() error {
+		return a.units.DaemonReload(ctx)
+	}); err != nil {
+		return err
+	}
+	if err := steps.run(app.Reload, app.Unit, func() error {
+		return runLifecycleCommand(ctx, a.units, app.Reload, app.Unit)
+	}); err != nil {
+		return err
+	}
+	if err := steps.run("wait-active", app.Unit, func() error {
+		return a.waitForUnitActive(ctx, app.Unit)
+	}); err != nil {
+		return err
+	}
+	if endpoint := app.HealthEndpoint; endpoint != "" {
+		return steps.run("health-check", endpoint, func() error {
+			return waitForHealthy(ctx, endpoint)
+		})
+	}
+	return nil
+}
+
+// waitForUnitActive waits for a unit that systemd has finished (re)starting to settle as active,
+// catching services that exit straight after starting: the unit has to stay active for the settle
+// period, and a unit that systemd restarts meanwhile, e.g. through Restart=always, has crashed
+func (a *API) waitForUnitActive(ctx context.Context, unit string) error {
+	var activeSince time.Time
+	var restarts *uint32
+	for {
+		status, err := a.units.Status(ctx, unit)
+		if err != nil {
+			return err
+		}
+		if restarts == nil {
+			restarts = &status.NRestarts
+		} else if status.NRestarts != *restarts {
+			return &units.JobFailedError{Unit: unit, Operation: "start", Result: "restarted by systemd while settling"}
+		}
+		switch status.ActiveState {
+		case "active":
+			if activeSince.IsZero() {
+				activeSince = time.Now()
+			}
+			if time.Since(activeSince) >= a.unitSettlePeriod {
+				return nil
+			}
+		case "failed":
+			return &units.JobFailedError{Unit: unit, Operation: "start", Result: status.Result}
+		default:
+			activeSince = time.Time{}
+		}
+
+		wait := unitActivePollInterval
+		if !activeSince.IsZero() {
+			if remaining := a.unitSettlePeriod - time.Since(activeSince); remaining < wait {
+				wait = remaining
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(units.ErrTimeout, "waiting for %s to become active, last state %q", unit, status.ActiveState)
+		case <-time.After(wait):
+		}
+	}
+}
+
+func waitForHealthy(ctx context.Context, endpoint string) error {
+	client := http.Client{Timeout: 2 * time.Second}
+	for {
+		resp, err := client.Get(endpoint)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("health check returned %d", resp.StatusCode)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "timed out waiting for %s to become healthy", endpoint)
+		case <-time.After(time.Second):
+		}
+	}
+}
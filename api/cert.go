@@ -1,15 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/supabase/supabase-admin-api/api/registry"
 )
 
 type cert struct {
@@ -71,6 +72,11 @@ func (a *API) UpdateCert(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 
+	kong, ok := a.applications.Get(kongApplication)
+	if !ok || !registry.HasUnit(kong) {
+		return sendJSON(w, http.StatusNotFound, "kong isn't a managed application")
+	}
+
 	cert, err := getCertAndKey(secretConfig)
 	if err != nil {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
@@ -78,15 +84,13 @@ func (a *API) UpdateCert(w http.ResponseWriter, r *http.Request) error {
 	writeToFile("/etc/kong/fullChain.pem", cert.FullChain)
 	writeToFile("/etc/kong/privKey.pem", cert.PrivKey)
 
-	// restart kong to load the new config
-	// need to do command as goroutine because adminapi gets killed and can't respond
-	go func() {
-		cmd := exec.Command("sudo", "systemctl", "reload", "kong.service")
-		_, err = cmd.Output()
-		if err != nil {
-			fmt.Fprint(os.Stderr, err.Error())
-		}
-	}()
+	// reload kong to load the new cert; the files are already written, so the reload isn't
+	// abandoned when the client goes away
+	ctx, cancel := context.WithTimeout(context.Background(), a.applyTimeout)
+	defer cancel()
+	if err := a.units.Reload(ctx, kong.Unit); err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
 
 	return sendJSON(w, http.StatusOK, "cert updated")
 }
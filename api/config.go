@@ -2,125 +2,92 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
-
-	"github.com/go-chi/chi"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/config_validation"
+	"github.com/supabase/supabase-admin-api/api/registry"
 )
 
-const postgrestConfPath string = "/etc/postgrest/base.conf"
-const postgrestConfPathOld string = "/etc/postgrest/old.base.conf"
-
-const postgresqlConfPath string = "/etc/postgresql-custom/custom-overrides.conf"
-const postgresqlConfPathOld string = "/etc/postgresql-custom/old.custom-overrides.conf"
-
-const pgbouncerConfPath string = "/etc/pgbouncer-custom/custom-overrides.ini"
-const pgbouncerConfPathOld string = "/etc/pgbouncer-custom/old.custom-overrides.ini"
-
-const pgListenConfPath string = "/etc/pg_listen.conf"
-const pgListenConfPathOld string = "/etc/old.pg_listen.conf"
-
-const gotrueEnvPath string = "/etc/gotrue.env"
-const gotrueEnvPathOld string = "/etc/old.gotrue.env"
-
-const realtimeConfPath string = "/etc/realtime.env"
-const realtimeEnvPathOldPattern string = "/etc/old.realtime.env"
-
-const kongYmlPath string = "/etc/kong/kong.yml"
-const kongYmlPathOld string = "/etc/kong/old.kong.yml"
-
-const adminapiEnvPath string = "/etc/adminapi/adminapi.yaml"
-const adminapiEnvPathOld string = "/etc/adminapi/old.adminapi.yaml"
-
-const walgEnvPath string = "/etc/wal-g/config.json"
-const walgEnvPathOld string = "/etc/wal-g/old.config.json"
+// ConfigValidationError is returned when a config file fails validation and was not written
+type ConfigValidationError struct {
+	Message string                        `json:"msg"`
+	Errors  []config_validation.LineError `json:"errors"`
+}
 
-const pgsodiumRootKeyPath string = "/etc/postgresql-custom/pgsodium_root.key"
-const pgsodiumRootKeyPathOld string = "/etc/postgresql-custom/old.pgsodium_root.key"
+func (e *ConfigValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, lineErr := range e.Errors {
+		messages = append(messages, lineErr.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(messages, "; "))
+}
 
 // FileContents holds the content of a config file
 type FileContents struct {
 	RawContents     string `json:"raw_contents"`
 	RestartServices bool   `json:"restart_services"`
+	// Redacted is the number of secrets masked in RawContents
+	Redacted int `json:"redacted,omitempty"`
+}
+
+// validateConfigFile runs the application's validator over contents, returning nil if there is
+// nothing wrong with them
+func validateConfigFile(app *registry.Application, contents []byte) *ConfigValidationError {
+	validator, ok := config_validation.Get(app.Validator)
+	if !ok {
+		return nil
+	}
+	if errs := validator(contents); len(errs) > 0 {
+		return &ConfigValidationError{
+			Message: fmt.Sprintf("invalid %s config", app.Name),
+			Errors:  errs,
+		}
+	}
+	return nil
 }
 
 // GetFileContents is the method for returning the contents of a given file
 func (a *API) GetFileContents(w http.ResponseWriter, r *http.Request) error {
-	var configFilePath string
-	application := chi.URLParam(r, "application")
-
-	switch application {
-	case "test":
-		configFilePath = "./README.md"
-	case "gotrue":
-		configFilePath = gotrueEnvPath
-	case "postgrest":
-		configFilePath = postgrestConfPath
-	case "pglisten":
-		configFilePath = pgListenConfPath
-	case "kong":
-		configFilePath = kongYmlPath
-	case "realtime":
-		configFilePath = realtimeConfPath
-	case "adminapi":
-		configFilePath = adminapiEnvPath
-	case "walg":
-		configFilePath = walgEnvPath
-	case "pgsodium":
-		configFilePath = pgsodiumRootKeyPath
-	}
-
-	contents, err := os.ReadFile(configFilePath)
+	app := getApplication(r)
+
+	contents, err := os.ReadFile(app.Config.Path)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	etag := configETag(contents)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	redacted, count, err := redactConfig(r, app, contents)
 	if err != nil {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 	fileContents := &FileContents{}
-	fileContents.RawContents = string(contents)
+	fileContents.RawContents = string(redacted)
+	fileContents.Redacted = count
 	return sendJSON(w, http.StatusOK, fileContents)
 }
 
-// SetFileContents sets the data in a given file
+// SetFileContents sets the data in a given file, or only previews the change with `?dry_run=true`
 func (a *API) SetFileContents(w http.ResponseWriter, r *http.Request) error {
-	var configFilePath string
-	var configFilePathOld string
-
-	application := chi.URLParam(r, "application")
-
-	switch application {
-	case "test":
-		configFilePath = "./README.md"
-		configFilePathOld = "./old.README.md"
-	case "gotrue":
-		configFilePath = gotrueEnvPath
-		configFilePathOld = gotrueEnvPathOld
-	case "postgrest":
-		configFilePath = postgrestConfPath
-		configFilePathOld = postgrestConfPathOld
-	case "pglisten":
-		configFilePath = pgListenConfPath
-		configFilePathOld = pgListenConfPathOld
-	case "kong":
-		configFilePath = kongYmlPath
-		configFilePathOld = kongYmlPathOld
-	case "realtime":
-		configFilePath = realtimeConfPath
-		configFilePathOld = realtimeEnvPathOldPattern
-	case "adminapi":
-		configFilePath = adminapiEnvPath
-		configFilePathOld = adminapiEnvPathOld
-	case "walg":
-		configFilePath = walgEnvPath
-		configFilePathOld = walgEnvPathOld
-	case "postgresql":
-		configFilePath = postgresqlConfPath
-		configFilePathOld = postgresqlConfPathOld
-	case "pgbouncer":
-		configFilePath = pgbouncerConfPath
-		configFilePathOld = pgbouncerConfPathOld
-	case "pgsodium":
-		configFilePath = pgsodiumRootKeyPath
-		configFilePathOld = pgsodiumRootKeyPathOld
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
 	}
+	return a.setFileContents(w, r, dryRun)
+}
+
+func (a *API) setFileContents(w http.ResponseWriter, r *http.Request, dryRun bool) error {
+	app := getApplication(r)
 
 	params := &FileContents{}
 
@@ -129,36 +96,157 @@ func (a *API) SetFileContents(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 
-	err := os.Rename(configFilePath, configFilePathOld)
+	unlock := a.lockConfig(app.Name)
+	defer unlock()
+
+	current, exists, err := readConfigFile(app)
 	if err != nil {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
+	if ok, err := checkConfigPreconditions(w, r, current, exists); !ok {
+		return err
+	}
 
-	f, err := os.Create(configFilePath)
+	contents, err := restoreSecrets(app, []byte(params.RawContents), current)
 	if err != nil {
-		return sendJSON(w, http.StatusInternalServerError, err.Error())
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if validationErr := validateConfigFile(app, contents); validationErr != nil {
+		return sendJSON(w, http.StatusUnprocessableEntity, validationErr)
 	}
 
-	defer f.Close()
+	if dryRun {
+		lifecycleCommand, err := getLifecycleCommand(r)
+		if err != nil {
+			return sendJSON(w, http.StatusBadRequest, err.Error())
+		}
+		preview, err := previewConfigFile(r, app, current, contents, plannedActions(app, params.RestartServices, lifecycleCommand))
+		if err != nil {
+			return sendJSON(w, http.StatusInternalServerError, err.Error())
+		}
+		w.Header().Set("ETag", configETag(current))
+		return sendJSON(w, http.StatusOK, preview)
+	}
 
-	bytesWritten, err := f.WriteString(params.RawContents)
-	_ = bytesWritten
-	if err != nil {
-		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	if params.RestartServices && canGuardApply(app) {
+		return a.sendGuardedApply(w, app, contents, getSubject(r))
 	}
-	err = f.Sync()
-	if err != nil {
-		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	if params.RestartServices && app.Restartable() {
+		lifecycleCommand, err := getLifecycleCommand(r)
+		if err != nil {
+			return sendJSON(w, http.StatusBadRequest, err.Error())
+		}
+		// refused restarts have to be refused before the config changes
+		if err := a.checkRestart(app.Unit, lifecycleCommand); err != nil {
+			return sendRestartError(w, err)
+		}
 	}
 
-	err = os.Chmod(configFilePath, 0664)
+	bytesWritten, err := a.writeConfigFile(app, contents, getSubject(r))
 	if err != nil {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
+	setConfigETag(w, app)
 
-	if params.RestartServices && application != "walg" {
+	if params.RestartServices && app.Restartable() {
 		return a.HandleLifecycleCommand(w, r)
 	}
 
 	return sendJSON(w, http.StatusOK, map[string]int{"bytes_written": bytesWritten})
 }
+
+// writeConfigFile atomically replaces an application's config file, keeping the previous version
+// around and recording the new one in the config history
+func (a *API) writeConfigFile(app *registry.Application, contents []byte, author string) (int, error) {
+	configFilePath, configFilePathOld := app.Config.Path, app.Config.BackupPath()
+
+	if err := a.recordBaselineRevision(app); err != nil {
+		return 0, err
+	}
+
+	// the backup holds the same secrets as the file, so it gets the same mode and owner
+	previous, err := os.ReadFile(configFilePath)
+	if err == nil {
+		err = writeFileAtomically(configFilePathOld, previous, app.Config.FileMode())
+		if err == nil {
+			err = chownConfigFile(app.Config, configFilePathOld)
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	return a.replaceConfigFile(app, contents, author)
+}
+
+// replaceConfigFile is writeConfigFile without the backup, for putting back a previous version
+// after a failed apply, which leaves the backup of the version from before the apply in place
+func (a *API) replaceConfigFile(app *registry.Application, contents []byte, author string) (int, error) {
+	configFilePath := app.Config.Path
+	if err := writeFileAtomically(configFilePath, contents, app.Config.FileMode()); err != nil {
+		return 0, err
+	}
+	if err := chownConfigFile(app.Config, configFilePath); err != nil {
+		return 0, err
+	}
+
+	if _, err := a.configHistory.Record(app.Name, contents, author); err != nil {
+		logrus.WithError(err).WithField("application", app.Name).Warn("failed to record config revision")
+	}
+
+	return len(contents), nil
+}
+
+// writeFileAtomically writes contents to a temporary file next to path and renames it into place,
+// so readers never observe a partially written config
+func writeFileAtomically(path string, contents []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// chownConfigFile hands a config file, or its backup, to the owner and group configured in the
+// registry, if any
+func chownConfigFile(config *registry.ConfigFile, path string) error {
+	if config.Owner == "" && config.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if config.Owner != "" {
+		owner, err := user.Lookup(config.Owner)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(owner.Uid); err != nil {
+			return err
+		}
+	}
+	if config.Group != "" {
+		group, err := user.LookupGroup(config.Group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
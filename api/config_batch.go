@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+// BatchConfigFile is a single config file of a batch write
+type BatchConfigFile struct {
+	Application string `json:"application"`
+	RawContents string `json:"raw_contents"`
+	// IfMatch is the ETag the file must currently have, as with If-Match on single writes
+	IfMatch string `json:"if_match,omitempty"`
+}
+
+// ConfigBatch holds config files that are written together
+type ConfigBatch struct {
+	Configs         []BatchConfigFile `json:"configs"`
+	RestartServices bool              `json:"restart_services"`
+}
+
+// BatchValidationError is returned when any config file of a batch fails validation, in which case
+// none of them were written
+type BatchValidationError struct {
+	Message      string                            `json:"msg"`
+	Applications map[string]*ConfigValidationError `json:"applications"`
+}
+
+// BatchReport describes how a batch of config files was written and their services restarted
+type BatchReport struct {
+	Applications []string          `json:"applications"`
+	Units        []string          `json:"units"`
+	Outcome      ApplyOutcome      `json:"outcome"`
+	BytesWritten map[string]int    `json:"bytes_written"`
+	ETags        map[string]string `json:"etags"`
+	Steps        ApplySteps        `json:"steps"`
+}
+
+// batchedConfig is a config file of a batch, ready to be written
+type batchedConfig struct {
+	app      *registry.Application
+	contents []byte
+	ifMatch  string
+	previous []byte
+	existed  bool
+}
+
+// SetBatchFileContents validates and writes several config files all-or-nothing, then restarts
+// the affected services once, in dependency order
+func (a *API) SetBatchFileContents(w http.ResponseWriter, r *http.Request) error {
+	params := &ConfigBatch{}
+	jsonDecoder := json.NewDecoder(r.Body)
+	if err := jsonDecoder.Decode(params); err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if len(params.Configs) == 0 {
+		return sendJSON(w, http.StatusBadRequest, "no configs to write")
+	}
+
+	batch := make(map[string]*batchedConfig)
+	for _, config := range params.Configs {
+		app, ok := a.applications.Get(config.Application)
+		if !ok || !registry.HasConfig(app) {
+			return sendJSON(w, http.StatusNotFound, fmt.Sprintf("unknown application %q", config.Application))
+		}
+		if _, ok := batch[app.Name]; ok {
+			return sendJSON(w, http.StatusBadRequest, fmt.Sprintf("%s is part of the batch more than once", app.Name))
+		}
+		batch[app.Name] = &batchedConfig{app: app, contents: []byte(config.RawContents), ifMatch: config.IfMatch}
+	}
+
+	// locks are always taken in name order, so concurrent batches can't deadlock
+	names := make([]string, 0, len(batch))
+	for name := range batch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		unlock := a.lockConfig(name)
+		defer unlock()
+	}
+
+	validationErr := &BatchValidationError{Message: "invalid configs", Applications: make(map[string]*ConfigValidationError)}
+	for _, name := range names {
+		b := batch[name]
+		current, exists, err := readConfigFile(b.app)
+		if err != nil {
+			return sendJSON(w, http.StatusInternalServerError, err.Error())
+		}
+		if b.ifMatch != "" && !etagMatches(b.ifMatch, configETag(current), exists) {
+			return sendJSON(w, http.StatusPreconditionFailed, fmt.Sprintf("%s config file has changed since it was read", b.app.Name))
+		}
+		b.previous, b.existed = current, exists
+		if b.contents, err = restoreSecrets(b.app, b.contents, current); err != nil {
+			return sendJSON(w, http.StatusBadRequest, fmt.Sprintf("%s: %s", b.app.Name, err))
+		}
+		if err := validateConfigFile(b.app, b.contents); err != nil {
+			validationErr.Applications[b.app.Name] = err
+		}
+	}
+	if len(validationErr.Applications) > 0 {
+		return sendJSON(w, http.StatusUnprocessableEntity, validationErr)
+	}
+
+	apps := make([]*registry.Application, 0, len(batch))
+	for _, name := range names {
+		apps = append(apps, batch[name].app)
+	}
+	apps = a.applications.InRestartOrder(apps)
+	if params.RestartServices {
+		for _, app := range restartPlan(apps) {
+			if err := a.checkConfigRestart(app); err != nil {
+				return sendRestartError(w, err)
+			}
+		}
+	}
+
+	// as guarded applies, the batch isn't tied to the request
+	report := a.applyBatch(context.Background(), batch, apps, params.RestartServices, getSubject(r))
+	if report.Outcome != Applied {
+		return sendJSON(w, http.StatusInternalServerError, report)
+	}
+	return sendJSON(w, http.StatusOK, report)
+}
+
+// applyBatch writes every config file of the batch, restoring the ones already written if any
+// write fails, and then runs the restart plan; if a service doesn't come back, every file is
+// restored and the services restarted so far are restarted again. The same goes once ctx is done
+func (a *API) applyBatch(ctx context.Context, batch map[string]*batchedConfig, apps []*registry.Application, restartServices bool, author string) *BatchReport {
+	report := &BatchReport{
+		Applications: make([]string, 0, len(apps)),
+		Units:        make([]string, 0),
+		BytesWritten: make(map[string]int),
+		ETags:        make(map[string]string),
+		Steps:        make(ApplySteps, 0),
+	}
+	for _, app := range apps {
+		report.Applications = append(report.Applications, app.Name)
+	}
+
+	written := make([]*batchedConfig, 0, len(apps))
+	restore := func() ApplyOutcome {
+		outcome := RolledBack
+		for i := len(written) - 1; i >= 0; i-- {
+			b := written[i]
+			if err := report.Steps.run("restore", b.app.Config.Path, func() error {
+				return a.restoreConfigFile(b.app, b.previous, b.existed)
+			}); err != nil {
+				outcome = RollbackFailed
+			}
+		}
+		return outcome
+	}
+
+	for _, app := range apps {
+		b := batch[app.Name]
+		err := report.Steps.run("write", app.Config.Path, func() error {
+			var err error
+			report.BytesWritten[app.Name], err = a.writeConfigFile(app, b.contents, author)
+			return err
+		})
+		if err != nil {
+			// a failed write may still have replaced the file, so it gets restored as well
+			written = append(written, b)
+			report.Outcome = restore()
+			return report
+		}
+		written = append(written, b)
+	}
+
+	if restartServices {
+		plan := restartPlan(apps)
+		for _, app := range plan {
+			report.Units = append(report.Units, app.Unit)
+		}
+		if failed := a.runRestartPlan(ctx, &report.Steps, plan, author, false); failed != -1 {
+			logrus.WithField("applications", report.Applications).Warn("services failed to come back after batch config change, restoring previous configs")
+			report.Outcome = restore()
+			if a.runRestartPlan(context.Background(), &report.Steps, plan[:failed+1], author, true) != -1 {
+				report.Outcome = RollbackFailed
+			}
+			return report
+		}
+	}
+
+	for _, app := range apps {
+		if contents, exists, err := readConfigFile(app); err == nil && exists {
+			report.ETags[app.Name] = configETag(contents)
+		}
+	}
+	report.Outcome = Applied
+	return report
+}
+
+// restartPlan picks the applications to restart for a set of changed config files, already in
+// restart order, restarting each unit only once; async applications are restarted last, once every
+// other unit was restarted and verified
+func restartPlan(apps []*registry.Application) []*registry.Application {
+	plan := make([]*registry.Application, 0)
+	planned := make(map[string]int)
+	for _, app := range apps {
+		if !app.Restartable() {
+			continue
+		}
+		if i, ok := planned[app.Unit]; ok {
+			// applications sharing a unit get the more thorough of their reload strategies
+			if app.Reload == registry.Restart {
+				plan[i] = app
+			}
+			continue
+		}
+		planned[app.Unit] = len(plan)
+		plan = append(plan, app)
+	}
+	sort.SliceStable(plan, func(i, j int) bool {
+		return !plan[i].Async && plan[j].Async
+	})
+	return plan
+}
+
+// runRestartPlan restarts every application of the plan in order, waiting for each one to come
+// back healthy before moving on; it returns the index of the first that didn't, or -1. Once ctx is
+// done, the rest of the plan is left alone and the index of the first application left is returned.
+// Restarts restoring previous configs aren't held back by the restart budget
+func (a *API) runRestartPlan(ctx context.Context, steps *ApplySteps, plan []*registry.Application, author string, restoring bool) int {
+	for i, app := range plan {
+		if ctx.Err() != nil {
+			return i
+		}
+		if !canGuardApply(app) {
+			if err := steps.run(Restart, app.Unit, func() error {
+				var err error
+				if restoring {
+					_, err = a.submitLifecycleJob(Restart, app.Unit, app.Async, author)
+				} else {
+					_, err = a.startLifecycleJob(Restart, app.Unit, app.Async, author)
+				}
+				return err
+			}); err != nil {
+				return i
+			}
+			continue
+		}
+		if err := a.restartAndVerify(ctx, steps, app, restoring); err != nil {
+			return i
+		}
+	}
+	return -1
+}
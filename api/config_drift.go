@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/supabase/supabase-admin-api/monitors"
+)
+
+// ConfigDriftReport is the drift state of a managed config file, along with the diff between the
+// version last written through the admin API and the file on disk if they differ
+type ConfigDriftReport struct {
+	monitors.ConfigDrift
+	Diff      string `json:"diff,omitempty"`
+	DiffError string `json:"diff_error,omitempty"`
+}
+
+// GetConfigDrift rehashes every managed config file and reports the ones edited outside of the
+// admin API
+func (a *API) GetConfigDrift(w http.ResponseWriter, r *http.Request) error {
+	drifts, err := a.monitoring.ConfigDrift().Check()
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+
+	reports := make([]ConfigDriftReport, 0, len(drifts))
+	for _, drift := range drifts {
+		report := ConfigDriftReport{ConfigDrift: drift}
+		if drift.Drifted {
+			app, _ := a.applications.Get(drift.Application)
+			_, expected, err := a.configHistory.Get(drift.Application, drift.Revision)
+			if err != nil {
+				return sendJSON(w, http.StatusInternalServerError, err.Error())
+			}
+			actual, err := os.ReadFile(drift.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return sendJSON(w, http.StatusInternalServerError, err.Error())
+			}
+			// a hand edited file may not even parse, in which case its secrets can't be masked
+			report.Diff, err = redactedDiff(r, app, fmt.Sprintf("%s@%d", drift.Application, drift.Revision), expected, drift.Path, actual)
+			if err != nil {
+				report.DiffError = err.Error()
+			}
+		}
+		reports = append(reports, report)
+	}
+	return sendJSON(w, http.StatusOK, reports)
+}
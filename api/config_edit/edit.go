@@ -0,0 +1,358 @@
+package config_edit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/supabase/supabase-admin-api/api/config_validation"
+)
+
+type Format = string
+
+const (
+	Env        Format = "env"
+	Ini        Format = "ini"
+	Postgresql Format = "postgresql"
+	Postgrest  Format = "postgrest"
+)
+
+type OperationType = string
+
+const (
+	Set   OperationType = "set"
+	Unset OperationType = "unset"
+)
+
+// Operation sets or removes a single key of a config file; Section only applies to INI files,
+// where an empty section refers to keys outside of any section
+type Operation struct {
+	Op      OperationType `json:"op"`
+	Key     string        `json:"key"`
+	Value   string        `json:"value,omitempty"`
+	Section string        `json:"section,omitempty"`
+}
+
+// IsSupported reports whether files of the given format can be edited key by key
+func IsSupported(format Format) bool {
+	switch format {
+	case Env, Ini, Postgresql, Postgrest:
+		return true
+	}
+	return false
+}
+
+// Apply runs the operations against contents in order, preserving comments, ordering and every
+// line that isn't touched
+func Apply(format Format, contents []byte, operations []Operation) ([]byte, error) {
+	f, err := formatOf(format)
+	if err != nil {
+		return nil, err
+	}
+	doc := parseDocument(contents)
+
+	for _, operation := range operations {
+		if operation.Key == "" {
+			return nil, fmt.Errorf("operation %+v has no key", operation)
+		}
+		if operation.Section != "" && format != Ini {
+			return nil, fmt.Errorf("sections are only supported for ini files")
+		}
+		var err error
+		switch operation.Op {
+		case Set:
+			err = doc.set(f, operation)
+		case Unset:
+			err = doc.unset(f, operation)
+		default:
+			err = fmt.Errorf("unknown operation %q", operation.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc.render(), nil
+}
+
+// Rewrite calls fn with the section, key and value of every assignment in contents, replacing the
+// value of the assignments fn returns true for; every other line is left untouched
+func Rewrite(format Format, contents []byte, fn func(section string, key string, value string) (string, bool)) ([]byte, error) {
+	f, err := formatOf(format)
+	if err != nil {
+		return nil, err
+	}
+	doc := parseDocument(contents)
+
+	section := ""
+	for i, text := range doc.lines {
+		line, err := f.parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		switch line.Kind {
+		case config_validation.Section:
+			section = line.Key
+		case config_validation.Entry:
+			value, ok := fn(section, line.Key, line.Value)
+			if !ok {
+				continue
+			}
+			if doc.lines[i], err = f.format(line.Key, value, text); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+		}
+	}
+	return doc.render(), nil
+}
+
+func formatOf(format Format) (lineFormat, error) {
+	switch format {
+	case Env:
+		return envFormat{}, nil
+	case Ini:
+		return iniFormat{}, nil
+	case Postgresql:
+		return postgresqlFormat{}, nil
+	case Postgrest:
+		return postgrestFormat{}, nil
+	}
+	return nil, fmt.Errorf("unsupported config format %q", format)
+}
+
+type lineFormat interface {
+	parse(line string) (config_validation.Line, error)
+	keysEqual(a string, b string) bool
+	// format renders a key/value assignment, reusing bits of the line it replaces where that
+	// matters (an `export` prefix, a trailing comment)
+	format(key string, value string, replacing string) (string, error)
+}
+
+type document struct {
+	lines           []string
+	trailingNewline bool
+}
+
+func parseDocument(contents []byte) *document {
+	text := string(contents)
+	doc := &document{lines: make([]string, 0), trailingNewline: text == "" || strings.HasSuffix(text, "\n")}
+	if text = strings.TrimSuffix(text, "\n"); text != "" {
+		doc.lines = strings.Split(text, "\n")
+	}
+	return doc
+}
+
+func (d *document) render() []byte {
+	output := strings.Join(d.lines, "\n")
+	if d.trailingNewline && len(d.lines) > 0 {
+		output += "\n"
+	}
+	return []byte(output)
+}
+
+// find returns the indexes of every line assigning key in the given section, and the index after
+// which a new assignment for the section belongs (-1 if the section doesn't exist)
+func (d *document) find(f lineFormat, key string, section string) ([]int, int, error) {
+	matches := make([]int, 0)
+	currentSection := ""
+	insertAfter := -1
+	if section == "" {
+		insertAfter = len(d.lines) - 1
+		for i, text := range d.lines {
+			if line, _ := f.parse(text); line.Kind == config_validation.Section {
+				insertAfter = lastNonBlank(d.lines, i-1)
+				break
+			}
+		}
+	}
+	for i, text := range d.lines {
+		line, err := f.parse(text)
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		switch line.Kind {
+		case config_validation.Section:
+			currentSection = line.Key
+			if currentSection == section {
+				insertAfter = i
+			}
+		case config_validation.Entry:
+			if currentSection != section {
+				continue
+			}
+			insertAfter = i
+			if f.keysEqual(line.Key, key) {
+				matches = append(matches, i)
+			}
+		}
+	}
+	return matches, insertAfter, nil
+}
+
+func lastNonBlank(lines []string, from int) int {
+	for from >= 0 && strings.TrimSpace(lines[from]) == "" {
+		from--
+	}
+	return from
+}
+
+func (d *document) set(f lineFormat, operation Operation) error {
+	matches, insertAfter, err := d.find(f, operation.Key, operation.Section)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		// the last assignment wins in every supported format, so that's the one to update
+		last := matches[len(matches)-1]
+		line, err := f.format(operation.Key, operation.Value, d.lines[last])
+		if err != nil {
+			return err
+		}
+		d.lines[last] = line
+		return nil
+	}
+
+	line, err := f.format(operation.Key, operation.Value, "")
+	if err != nil {
+		return err
+	}
+	if insertAfter == -1 && operation.Section != "" {
+		if len(d.lines) > 0 && strings.TrimSpace(d.lines[len(d.lines)-1]) != "" {
+			d.lines = append(d.lines, "")
+		}
+		d.lines = append(d.lines, fmt.Sprintf("[%s]", operation.Section), line)
+		return nil
+	}
+	d.insert(insertAfter+1, line)
+	return nil
+}
+
+func (d *document) insert(at int, line string) {
+	d.lines = append(d.lines, "")
+	copy(d.lines[at+1:], d.lines[at:])
+	d.lines[at] = line
+}
+
+func (d *document) unset(f lineFormat, operation Operation) error {
+	matches, _, err := d.find(f, operation.Key, operation.Section)
+	if err != nil {
+		return err
+	}
+	for i := len(matches) - 1; i >= 0; i-- {
+		d.lines = append(d.lines[:matches[i]], d.lines[matches[i]+1:]...)
+	}
+	return nil
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var envBareValuePattern = regexp.MustCompile(`^[^\s"'#\\]*$`)
+
+type envFormat struct{}
+
+func (envFormat) parse(line string) (config_validation.Line, error) {
+	return config_validation.ParseEnvLine(line)
+}
+
+func (envFormat) keysEqual(a string, b string) bool {
+	return a == b
+}
+
+func (envFormat) format(key string, value string, replacing string) (string, error) {
+	if !envKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("invalid variable name %q", key)
+	}
+	if !envBareValuePattern.MatchString(value) {
+		value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+	}
+	prefix := ""
+	if strings.HasPrefix(strings.TrimSpace(replacing), "export ") {
+		prefix = "export "
+	}
+	return fmt.Sprintf("%s%s=%s", prefix, key, value), nil
+}
+
+type iniFormat struct{}
+
+func (iniFormat) parse(line string) (config_validation.Line, error) {
+	return config_validation.ParseIniLine(line)
+}
+
+func (iniFormat) keysEqual(a string, b string) bool {
+	return a == b
+}
+
+func (iniFormat) format(key string, value string, _ string) (string, error) {
+	if strings.ContainsAny(key, " \t=[]") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	if strings.Contains(value, "\n") {
+		return "", fmt.Errorf("%s: values can't span multiple lines", key)
+	}
+	return fmt.Sprintf("%s = %s", key, value), nil
+}
+
+var postgresqlBareValuePattern = regexp.MustCompile(`^-?[0-9.]+[A-Za-z]*$|^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type postgresqlFormat struct{}
+
+func (postgresqlFormat) parse(line string) (config_validation.Line, error) {
+	return config_validation.ParsePostgresqlConfLine(line)
+}
+
+func (postgresqlFormat) keysEqual(a string, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+func (postgresqlFormat) format(key string, value string, replacing string) (string, error) {
+	if !config_validation.IsKnownGuc(key) {
+		return "", fmt.Errorf("unrecognized configuration parameter %q", key)
+	}
+	if strings.Contains(value, "\n") {
+		return "", fmt.Errorf("%s: values can't span multiple lines", key)
+	}
+	if !postgresqlBareValuePattern.MatchString(value) {
+		value = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	line := fmt.Sprintf("%s = %s", key, value)
+	if replaced, err := config_validation.ParsePostgresqlConfLine(replacing); err == nil && replaced.Comment != "" {
+		line += "\t" + replaced.Comment
+	}
+	return line, nil
+}
+
+var postgrestBareValuePattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$|^(true|false|on|off)$`)
+
+var postgrestPrefixPattern = regexp.MustCompile(`^\s*[^=]*?\s*=\s*`)
+
+type postgrestFormat struct{}
+
+func (postgrestFormat) parse(line string) (config_validation.Line, error) {
+	return config_validation.ParsePostgrestLine(line)
+}
+
+func (postgrestFormat) keysEqual(a string, b string) bool {
+	return a == b
+}
+
+func (postgrestFormat) format(key string, value string, replacing string) (string, error) {
+	if _, err := config_validation.ParsePostgrestLine(key + " = 0"); err != nil {
+		return "", err
+	}
+	if strings.Contains(value, "\n") {
+		return "", fmt.Errorf("%s: values can't span multiple lines", key)
+	}
+	if !postgrestBareValuePattern.MatchString(value) {
+		value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	replaced, err := config_validation.ParsePostgrestLine(replacing)
+	if err != nil || replaced.Kind != config_validation.Entry || replaced.Key != key {
+		return fmt.Sprintf("%s = %s", key, value), nil
+	}
+	// keep the spacing of the line being replaced, around the = and before the comment
+	prefix := postgrestPrefixPattern.FindString(replacing)
+	if replaced.Comment == "" {
+		return prefix + value, nil
+	}
+	beforeComment := strings.TrimRight(replacing[:strings.LastIndex(replacing, replaced.Comment)], " \t")
+	return prefix + value + replacing[len(beforeComment):], nil
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/supabase/supabase-admin-api/api/config_history"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+func configETag(contents []byte) string {
+	return `"` + config_history.Hash(contents) + `"`
+}
+
+// etagMatches reports whether a comma separated If-Match / If-None-Match header value matches the
+// given ETag, or the file's existence in the case of `*`
+func etagMatches(header string, etag string, exists bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" && exists {
+			return true
+		}
+		if exists && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfigPreconditions answers with a 412 if the request's If-Match or If-None-Match header
+// doesn't hold for the config file currently on disk, returning false in that case
+func checkConfigPreconditions(w http.ResponseWriter, r *http.Request, current []byte, exists bool) (bool, error) {
+	etag := configETag(current)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, etag, exists) {
+		return false, sendJSON(w, http.StatusPreconditionFailed, "config file has changed since it was read")
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, exists) {
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			return false, sendJSON(w, http.StatusPreconditionFailed, "config file already exists")
+		}
+		return false, sendJSON(w, http.StatusPreconditionFailed, "config file has not changed")
+	}
+	return true, nil
+}
+
+// readConfigFile returns the current contents of an application's config file, and whether it exists
+func readConfigFile(app *registry.Application) ([]byte, bool, error) {
+	contents, err := os.ReadFile(app.Config.Path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return contents, true, nil
+}
+
+// setConfigETag sets the ETag of the config file as it is on disk after a write; the write may
+// have been rolled back, so the contents that were sent can't be relied on
+func setConfigETag(w http.ResponseWriter, app *registry.Application) {
+	if contents, exists, err := readConfigFile(app); err == nil && exists {
+		w.Header().Set("ETag", configETag(contents))
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/config_history"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+const DefaultConfigHistoryDir = "/var/lib/adminapi/config-history"
+const DefaultConfigHistoryRetention = 20
+
+// RevisionContents holds a single revision of a config file
+type RevisionContents struct {
+	config_history.Revision
+	RawContents string `json:"raw_contents"`
+}
+
+// RevisionDiff holds the unified diff between two revisions of a config file
+type RevisionDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"`
+}
+
+// RollbackParams controls what happens after a rollback has been written
+type RollbackParams struct {
+	RestartServices bool `json:"restart_services"`
+}
+
+// recordBaselineRevision stores the file currently on disk as the first revision, so that the
+// very first API write can be rolled back as well
+func (a *API) recordBaselineRevision(app *registry.Application) error {
+	_, err := a.configHistory.RecordBaseline(app.Name, app.Config.Path)
+	return err
+}
+
+func revisionParam(r *http.Request, name string) (int, error) {
+	rev, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision %q", chi.URLParam(r, name))
+	}
+	return rev, nil
+}
+
+func (a *API) getRevision(w http.ResponseWriter, application string, rev int) ([]byte, bool, error) {
+	_, contents, err := a.configHistory.Get(application, rev)
+	if err == config_history.ErrRevisionNotFound {
+		return nil, false, sendJSON(w, http.StatusNotFound, fmt.Sprintf("revision %d of %s not found", rev, application))
+	}
+	if err != nil {
+		return nil, false, sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return contents, true, nil
+}
+
+// GetConfigHistory lists the retained revisions of an application's config file
+func (a *API) GetConfigHistory(w http.ResponseWriter, r *http.Request) error {
+	application := getApplication(r).Name
+	revisions, err := a.configHistory.List(application)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, revisions)
+}
+
+// GetConfigRevision returns the contents of a single revision of an application's config file
+func (a *API) GetConfigRevision(w http.ResponseWriter, r *http.Request) error {
+	app := getApplication(r)
+	application := app.Name
+	rev, err := revisionParam(r, "rev")
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	revision, contents, err := a.configHistory.Get(application, rev)
+	if err == config_history.ErrRevisionNotFound {
+		return sendJSON(w, http.StatusNotFound, fmt.Sprintf("revision %d of %s not found", rev, application))
+	}
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if contents, _, err = redactConfig(r, app, contents); err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, &RevisionContents{Revision: *revision, RawContents: string(contents)})
+}
+
+// DiffConfigRevisions returns a unified diff between two revisions of an application's config file
+func (a *API) DiffConfigRevisions(w http.ResponseWriter, r *http.Request) error {
+	app := getApplication(r)
+	application := app.Name
+	from, err := revisionParam(r, "from")
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	to, err := revisionParam(r, "to")
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+
+	fromContents, ok, err := a.getRevision(w, application, from)
+	if !ok {
+		return err
+	}
+	toContents, ok, err := a.getRevision(w, application, to)
+	if !ok {
+		return err
+	}
+
+	diff, err := redactedDiff(r, app, fmt.Sprintf("%s@%d", application, from), fromContents, fmt.Sprintf("%s@%d", application, to), toContents)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, &RevisionDiff{From: from, To: to, Diff: diff})
+}
+
+// RollbackConfig writes a previous revision of an application's config file back to disk,
+// optionally restarting the service afterwards
+func (a *API) RollbackConfig(w http.ResponseWriter, r *http.Request) error {
+	app := getApplication(r)
+	rev, err := revisionParam(r, "rev")
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+
+	params := &RollbackParams{}
+	jsonDecoder := json.NewDecoder(r.Body)
+	if err := jsonDecoder.Decode(params); err != nil && err != io.EOF {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+
+	contents, ok, err := a.getRevision(w, app.Name, rev)
+	if !ok {
+		return err
+	}
+
+	unlock := a.lockConfig(app.Name)
+	defer unlock()
+
+	current, exists, err := readConfigFile(app)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if ok, err := checkConfigPreconditions(w, r, current, exists); !ok {
+		return err
+	}
+
+	if params.RestartServices && canGuardApply(app) {
+		return a.sendGuardedApply(w, app, contents, getSubject(r))
+	}
+	if params.RestartServices && app.Restartable() {
+		lifecycleCommand, err := getLifecycleCommand(r)
+		if err != nil {
+			return sendJSON(w, http.StatusBadRequest, err.Error())
+		}
+		// refused restarts have to be refused before the config changes
+		if err := a.checkRestart(app.Unit, lifecycleCommand); err != nil {
+			return sendRestartError(w, err)
+		}
+	}
+
+	bytesWritten, err := a.writeConfigFile(app, contents, getSubject(r))
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	setConfigETag(w, app)
+
+	if params.RestartServices && app.Restartable() {
+		return a.HandleLifecycleCommand(w, r)
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]int{"bytes_written": bytesWritten, "rolled_back_to": rev})
+}
@@ -0,0 +1,17 @@
+package config_history
+
+import (
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// UnifiedDiff renders a unified diff between two versions of a config file; an empty string
+// means the versions are identical
+func UnifiedDiff(fromName string, from []byte, toName string, to []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(from)),
+		B:        difflib.SplitLines(string(to)),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
@@ -0,0 +1,178 @@
+package config_history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const indexFileName = "index.json"
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revision describes a single retained generation of a managed config file
+type Revision struct {
+	Rev       int       `json:"rev"`
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+	Author    string    `json:"author"`
+	Size      int       `json:"size"`
+}
+
+// Store keeps the last N generations of every managed config file on disk, laid out as
+// <dir>/<application>/index.json plus one <rev> file per retained generation
+type Store struct {
+	dir       string
+	retention int
+	mu        sync.Mutex
+}
+
+func NewStore(dir string, retention int) *Store {
+	return &Store{dir: dir, retention: retention}
+}
+
+func Hash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record stores contents as the newest revision of the application's config, pruning the
+// oldest generations beyond the retention limit
+func (s *Store) Record(application string, contents []byte, author string) (*Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	appDir := filepath.Join(s.dir, application)
+	if err := os.MkdirAll(appDir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "couldn't create history directory for %s", application)
+	}
+	revisions, err := s.readIndex(application)
+	if err != nil {
+		return nil, err
+	}
+
+	next := 1
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Rev + 1
+	}
+	revision := Revision{
+		Rev:       next,
+		Timestamp: time.Now().UTC(),
+		Hash:      Hash(contents),
+		Author:    author,
+		Size:      len(contents),
+	}
+	if err := os.WriteFile(s.revisionPath(application, next), contents, 0640); err != nil {
+		return nil, errors.Wrapf(err, "couldn't store revision %d of %s", next, application)
+	}
+	revisions = append(revisions, revision)
+
+	if s.retention > 0 && len(revisions) > s.retention {
+		for _, pruned := range revisions[:len(revisions)-s.retention] {
+			if err := os.Remove(s.revisionPath(application, pruned.Rev)); err != nil && !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "couldn't prune revision %d of %s", pruned.Rev, application)
+			}
+		}
+		revisions = revisions[len(revisions)-s.retention:]
+	}
+
+	if err := s.writeIndex(application, revisions); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// RecordBaseline stores the file at path as the first revision of the application's config if
+// there is no history for it yet, returning the latest revision either way (nil if the file doesn't
+// exist and there's no history)
+func (s *Store) RecordBaseline(application string, path string) (*Revision, error) {
+	latest, err := s.Latest(application)
+	if err != nil || latest != nil {
+		return latest, err
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revision, err := s.Record(application, contents, "")
+	return revision, errors.Wrapf(err, "couldn't record baseline revision of %s", application)
+}
+
+// List returns the retained revisions of an application's config, oldest first
+func (s *Store) List(application string) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readIndex(application)
+}
+
+// Latest returns the newest retained revision, or nil if there is no history yet
+func (s *Store) Latest(application string) (*Revision, error) {
+	revisions, err := s.List(application)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return &revisions[len(revisions)-1], nil
+}
+
+// Get returns the metadata and contents of a single revision
+func (s *Store) Get(application string, rev int) (*Revision, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revisions, err := s.readIndex(application)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx := sort.Search(len(revisions), func(i int) bool { return revisions[i].Rev >= rev })
+	if idx == len(revisions) || revisions[idx].Rev != rev {
+		return nil, nil, ErrRevisionNotFound
+	}
+	contents, err := os.ReadFile(s.revisionPath(application, rev))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "couldn't read revision %d of %s", rev, application)
+	}
+	return &revisions[idx], contents, nil
+}
+
+func (s *Store) revisionPath(application string, rev int) string {
+	return filepath.Join(s.dir, application, fmt.Sprintf("%d", rev))
+}
+
+func (s *Store) readIndex(application string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	data, err := os.ReadFile(filepath.Join(s.dir, application, indexFileName))
+	if os.IsNotExist(err) {
+		return revisions, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read history index of %s", application)
+	}
+	if err := json.Unmarshal(data, &revisions); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse history index of %s", application)
+	}
+	return revisions, nil
+}
+
+func (s *Store) writeIndex(application string, revisions []Revision) error {
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		return errors.Wrap(err, "couldn't serialize history index")
+	}
+	indexPath := filepath.Join(s.dir, application, indexFileName)
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return errors.Wrapf(err, "couldn't write history index of %s", application)
+	}
+	return errors.Wrapf(os.Rename(tmpPath, indexPath), "couldn't replace history index of %s", application)
+}
@@ -0,0 +1,46 @@
+package config_history
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStoreRetention(t *testing.T) {
+	store := NewStore(t.TempDir(), 3)
+	for _, contents := range []string{"a=1\n", "a=2\n", "a=3\n", "a=4\n"} {
+		if _, err := store.Record("gotrue", []byte(contents), "operator"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := store.List("gotrue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Rev != 2 || revisions[2].Rev != 4 {
+		t.Fatalf("expected revisions 2..4 to be retained, got %+v", revisions)
+	}
+	if _, _, err := store.Get("gotrue", 1); err != ErrRevisionNotFound {
+		t.Fatalf("expected pruned revision to be gone, got %+v", err)
+	}
+	revision, contents, err := store.Get("gotrue", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "a=4\n" || revision.Author != "operator" || revision.Hash != Hash(contents) {
+		t.Fatalf("unexpected revision %+v with contents %q", revision, contents)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	diff, err := UnifiedDiff("rev/1", []byte("a=1\nb=2\n"), "rev/2", []byte("a=1\nb=3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-b=2\n") || !strings.Contains(diff, "+b=3\n") {
+		t.Fatalf("unexpected diff %q", diff)
+	}
+	if diff, _ := UnifiedDiff("a", []byte("x\n"), "b", []byte("x\n")); diff != "" {
+		t.Fatalf("expected no diff for identical contents, got %q", diff)
+	}
+}
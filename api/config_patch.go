@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/supabase/supabase-admin-api/api/config_edit"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+)
+
+// ConfigPatch holds a set of key-level edits to a config file
+type ConfigPatch struct {
+	Operations      []config_edit.Operation `json:"operations"`
+	RestartServices bool                    `json:"restart_services"`
+}
+
+// ConfigPatchResult reports what a patch changed, and how the service was restarted if requested
+type ConfigPatchResult struct {
+	Diff         string       `json:"diff"`
+	BytesWritten int          `json:"bytes_written"`
+	Apply        *ApplyReport `json:"apply,omitempty"`
+	Job          *jobs.Job    `json:"job,omitempty"`
+}
+
+// lockConfig serializes read-modify-write cycles on an application's config file
+func (a *API) lockConfig(application string) func() {
+	mu, _ := a.configLocks.LoadOrStore(application, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// PatchFileContents sets or removes individual keys of an env, ini or postgresql.conf style config
+// file, leaving everything else in the file untouched; `?dry_run=true` only previews the change
+func (a *API) PatchFileContents(w http.ResponseWriter, r *http.Request) error {
+	app := getApplication(r)
+	if !config_edit.IsSupported(app.Format) {
+		return sendJSON(w, http.StatusBadRequest, fmt.Sprintf("%s config can't be edited key by key", app.Name))
+	}
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+
+	params := &ConfigPatch{}
+	jsonDecoder := json.NewDecoder(r.Body)
+	if err := jsonDecoder.Decode(params); err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+
+	unlock := a.lockConfig(app.Name)
+	defer unlock()
+
+	current, exists, err := readConfigFile(app)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if ok, err := checkConfigPreconditions(w, r, current, exists); !ok {
+		return err
+	}
+	patched, err := config_edit.Apply(app.Format, current, params.Operations)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if patched, err = restoreSecrets(app, patched, current); err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if validationErr := validateConfigFile(app, patched); validationErr != nil {
+		return sendJSON(w, http.StatusUnprocessableEntity, validationErr)
+	}
+
+	if dryRun {
+		actions := make([]LifecycleAction, 0)
+		if !bytes.Equal(current, patched) {
+			actions = plannedActions(app, params.RestartServices, Restart)
+		}
+		preview, err := previewConfigFile(r, app, current, patched, actions)
+		if err != nil {
+			return sendJSON(w, http.StatusInternalServerError, err.Error())
+		}
+		w.Header().Set("ETag", configETag(current))
+		return sendJSON(w, http.StatusOK, preview)
+	}
+
+	result := &ConfigPatchResult{}
+	result.Diff, err = redactedDiff(r, app, app.Config.Path, current, app.Config.Path, patched)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if bytes.Equal(current, patched) {
+		w.Header().Set("ETag", configETag(current))
+		return sendJSON(w, http.StatusOK, result)
+	}
+
+	if params.RestartServices && canGuardApply(app) {
+		result.Apply, err = a.guardedApply(context.Background(), app, patched, getSubject(r))
+		if err != nil {
+			return sendRestartError(w, err)
+		}
+		result.BytesWritten = result.Apply.BytesWritten
+		setConfigETag(w, app)
+		if result.Apply.Outcome != Applied {
+			return sendJSON(w, http.StatusInternalServerError, result)
+		}
+		return sendJSON(w, http.StatusOK, result)
+	}
+
+	if params.RestartServices {
+		if err := a.checkConfigRestart(app); err != nil {
+			return sendRestartError(w, err)
+		}
+	}
+	result.BytesWritten, err = a.writeConfigFile(app, patched, getSubject(r))
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	setConfigETag(w, app)
+	if params.RestartServices && app.Restartable() {
+		if result.Job, err = a.startLifecycleJob(Restart, app.Unit, app.Async, getSubject(r)); err != nil {
+			return sendRestartError(w, err)
+		}
+	}
+	return sendJSON(w, http.StatusOK, result)
+}
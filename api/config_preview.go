@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+// LifecycleAction is a systemd action a config change would trigger
+type LifecycleAction struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+}
+
+// ConfigPreview describes what a config write would change, without it having been written
+type ConfigPreview struct {
+	Application string            `json:"application"`
+	Path        string            `json:"path"`
+	Changed     bool              `json:"changed"`
+	Diff        string            `json:"diff"`
+	Actions     []LifecycleAction `json:"actions"`
+}
+
+// isDryRun reports whether the request only asks for a preview of a config write
+func isDryRun(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid dry_run value %q", value)
+	}
+	return dryRun, nil
+}
+
+// plannedActions lists the lifecycle actions writing the application's config would run, mirroring
+// restartAndVerify for guarded applies and startLifecycleJob otherwise
+func plannedActions(app *registry.Application, restartServices bool, lifecycleCommand LifecycleCommand) []LifecycleAction {
+	actions := make([]LifecycleAction, 0)
+	if !restartServices || !app.Restartable() {
+		return actions
+	}
+	if !canGuardApply(app) {
+		return append(actions,
+			LifecycleAction{Action: "daemon-reload", Target: "systemd"},
+			LifecycleAction{Action: lifecycleCommand, Target: app.Unit},
+		)
+	}
+	actions = append(actions,
+		LifecycleAction{Action: "daemon-reload", Target: "systemd"},
+		LifecycleAction{Action: app.Reload, Target: app.Unit},
+		LifecycleAction{Action: "wait-active", Target: app.Unit},
+	)
+	if app.HealthEndpoint != "" {
+		actions = append(actions, LifecycleAction{Action: "health-check", Target: app.HealthEndpoint})
+	}
+	return actions
+}
+
+// previewConfigFile builds the preview of replacing current with contents
+func previewConfigFile(r *http.Request, app *registry.Application, current []byte, contents []byte, actions []LifecycleAction) (*ConfigPreview, error) {
+	diff, err := redactedDiff(r, app, app.Config.Path, current, app.Config.Path, contents)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigPreview{
+		Application: app.Name,
+		Path:        app.Config.Path,
+		Changed:     !bytes.Equal(current, contents),
+		Diff:        diff,
+		Actions:     actions,
+	}, nil
+}
+
+// PreviewFileContents validates a new config file and returns its diff against the file on disk,
+// along with the lifecycle actions writing it would run, without touching disk or systemd
+func (a *API) PreviewFileContents(w http.ResponseWriter, r *http.Request) error {
+	return a.setFileContents(w, r, true)
+}
@@ -0,0 +1,334 @@
+package config_redaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scalar is a value of a structured config file, and the bytes it is written as
+type scalar struct {
+	path []string
+	// value is the decoded value: a string, json.Number, bool or nil
+	value interface{}
+	start int
+	end   int
+}
+
+// quotedPlaceholder is what replaces redacted values of structured config files, a string in
+// both JSON and YAML
+var quotedPlaceholder = []byte(`"` + Placeholder + `"`)
+
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil
+		}
+	}
+	return segments
+}
+
+// matchesPath reports whether a scalar's path matches one of the patterns, `*` matching any key
+// or list item
+func matchesPath(patterns [][]string, path []string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) != len(path) {
+			continue
+		}
+		matches := true
+		for i, segment := range pattern {
+			if segment != "*" && segment != path[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonScalars lists every scalar of a JSON document along with where it is written
+func jsonScalars(contents []byte) ([]scalar, error) {
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	scalars := make([]scalar, 0)
+
+	var parse func(path []string) error
+	parse = func(path []string) error {
+		// the decoder consumes the separators before a value along with it
+		start := int(decoder.InputOffset())
+		for start < len(contents) && strings.IndexByte(" \t\r\n:,", contents[start]) >= 0 {
+			start++
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				if err := parse(append(append([]string{}, path...), key.(string))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := parse(append(append([]string{}, path...), strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+			return err
+		}
+		scalars = append(scalars, scalar{path: path, value: token, start: start, end: int(decoder.InputOffset())})
+		return nil
+	}
+	if err := parse(nil); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return scalars, nil
+}
+
+// replacement puts text in place of the bytes from start to end
+type replacement struct {
+	start int
+	end   int
+	text  []byte
+}
+
+// splice applies replacements to contents, leaving every other byte as it is
+func splice(contents []byte, replacements []replacement) []byte {
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start < replacements[j].start
+	})
+	var b bytes.Buffer
+	last := 0
+	for _, r := range replacements {
+		b.Write(contents[last:r.start])
+		b.Write(r.text)
+		last = r.end
+	}
+	b.Write(contents[last:])
+	return b.Bytes()
+}
+
+// rewritePaths calls fn for every scalar matching one of the patterns, replacing the bytes it is
+// written as with the ones fn returns; the rest of the file is left untouched, so rewriting
+// nothing returns contents as they were
+func rewritePaths(contents []byte, scalars []scalar, patterns [][]string, fn func(value scalar) ([]byte, bool, error)) ([]byte, error) {
+	replacements := make([]replacement, 0)
+	for _, value := range scalars {
+		if !matchesPath(patterns, value.path) {
+			continue
+		}
+		text, replace, err := fn(value)
+		if err != nil {
+			return nil, err
+		}
+		if replace {
+			replacements = append(replacements, replacement{start: value.start, end: value.end, text: text})
+		}
+	}
+	if len(replacements) == 0 {
+		return contents, nil
+	}
+	return splice(contents, replacements), nil
+}
+
+// yamlScalars lists every scalar of a YAML document along with where its value is written; tags
+// and anchors in front of a value are left out of it
+func yamlScalars(contents []byte) ([]scalar, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return []scalar{}, nil
+	}
+	lines := make([]int, 1, bytes.Count(contents, []byte{'\n'})+1)
+	for i, c := range contents {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	y := &yamlDocument{contents: contents, lines: lines, scalars: make([]scalar, 0)}
+	if err := y.walk(doc.Content[0], nil, -1, false); err != nil {
+		return nil, err
+	}
+	return y.scalars, nil
+}
+
+type yamlDocument struct {
+	contents []byte
+	// lines holds the offset each line starts at
+	lines   []int
+	scalars []scalar
+}
+
+// walk collects the scalars under node; indent is the indentation of the block holding node,
+// which bounds block scalars, and flow is set inside flow collections
+func (y *yamlDocument) walk(node *yaml.Node, path []string, indent int, flow bool) error {
+	flow = flow || node.Style&yaml.FlowStyle != 0
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			if err := y.walk(value, append(append([]string{}, path...), key.Value), key.Column-1, flow); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := y.walk(item, append(append([]string{}, path...), strconv.Itoa(i)), node.Column-1, flow); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" && node.Value == "" {
+			// a key without a value has nothing to redact, nor to keep
+			return nil
+		}
+		start, end, err := y.span(node, indent, flow)
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+		}
+		var value interface{} = node.Value
+		if node.Tag == "!!null" {
+			value = nil
+		}
+		y.scalars = append(y.scalars, scalar{path: path, value: value, start: start, end: end})
+	}
+	return nil
+}
+
+// offset returns where a 1-based line and column, counted in characters, is in the document
+func (y *yamlDocument) offset(line int, column int) int {
+	if line < 1 || line > len(y.lines) {
+		return len(y.contents)
+	}
+	offset := y.lines[line-1]
+	for ; column > 1 && offset < len(y.contents); column-- {
+		_, size := utf8.DecodeRune(y.contents[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// lineEnd returns the offset of the end of the line holding offset, before its line break
+func (y *yamlDocument) lineEnd(offset int) int {
+	if i := bytes.IndexByte(y.contents[offset:], '\n'); i >= 0 {
+		offset += i
+	} else {
+		offset = len(y.contents)
+	}
+	if offset > 0 && y.contents[offset-1] == '\r' {
+		offset--
+	}
+	return offset
+}
+
+// nextLine returns the offset of the line after the one holding offset
+func (y *yamlDocument) nextLine(offset int) int {
+	if i := bytes.IndexByte(y.contents[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(y.contents)
+}
+
+// span finds the bytes a scalar's value is written as
+func (y *yamlDocument) span(node *yaml.Node, indent int, flow bool) (int, int, error) {
+	contents := y.contents
+	start := y.offset(node.Line, node.Column)
+	// skip the tag and anchor of the value
+	for start < len(contents) && (contents[start] == '!' || contents[start] == '&') {
+		for start < len(contents) && contents[start] != ' ' && contents[start] != '\n' {
+			start++
+		}
+		for start < len(contents) && contents[start] == ' ' {
+			start++
+		}
+	}
+	if start >= len(contents) {
+		return 0, 0, fmt.Errorf("couldn't find the value")
+	}
+
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(contents); i++ {
+			switch contents[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, i + 1, nil
+			}
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(contents); i++ {
+			if contents[i] != '\'' {
+				continue
+			}
+			if i+1 < len(contents) && contents[i+1] == '\'' {
+				i++
+				continue
+			}
+			return start, i + 1, nil
+		}
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		// the block goes on as long as its lines are blank or indented past the block holding it
+		end := y.lineEnd(start)
+		for next := y.nextLine(start); next < len(contents); next = y.nextLine(next) {
+			line := contents[next:y.lineEnd(next)]
+			text := bytes.TrimLeft(line, " ")
+			if len(bytes.TrimSpace(text)) == 0 {
+				continue
+			}
+			if len(line)-len(text) <= indent {
+				break
+			}
+			end = y.lineEnd(next)
+		}
+		return start, end, nil
+	default:
+		end := y.lineEnd(start)
+		if i := bytes.Index(contents[start:end], []byte(" #")); i >= 0 {
+			end = start + i
+		}
+		if flow {
+			if i := bytes.IndexAny(contents[start:end], ",]}"); i >= 0 {
+				end = start + i
+			}
+		}
+		end = start + len(bytes.TrimRight(contents[start:end], " \t"))
+		// plain values spanning several lines aren't supported
+		if string(contents[start:end]) == node.Value {
+			return start, end, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("couldn't find the value")
+}
@@ -0,0 +1,201 @@
+package config_redaction
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/supabase/supabase-admin-api/api/config_edit"
+)
+
+// Placeholder replaces every redacted value; sending it back in a config write keeps the value
+// that is currently on disk
+const Placeholder = "**redacted**"
+
+// Rules describe which values of a config file are secrets
+type Rules struct {
+	// Keys are regular expressions matched against the keys of env, ini, postgresql.conf and
+	// postgrest config files
+	Keys []string `yaml:"keys" required:"false"`
+	// JSONPaths are dot separated paths into JSON config files, `*` matching any key or list item
+	JSONPaths []string `yaml:"json_paths" required:"false"`
+	// YAMLPaths are the same for YAML config files
+	YAMLPaths []string `yaml:"yaml_paths" required:"false"`
+	// WholeFile redacts the entire file, e.g. for key files
+	WholeFile bool `yaml:"whole_file" required:"false"`
+
+	keys      []*regexp.Regexp
+	jsonPaths [][]string
+	yamlPaths [][]string
+}
+
+// Compile checks the rules, and must be called before they're used
+func (r *Rules) Compile() error {
+	r.keys = make([]*regexp.Regexp, 0, len(r.Keys))
+	for _, key := range r.Keys {
+		pattern, err := regexp.Compile(key)
+		if err != nil {
+			return fmt.Errorf("invalid key pattern %q: %s", key, err)
+		}
+		r.keys = append(r.keys, pattern)
+	}
+	if len(r.JSONPaths) > 0 && len(r.YAMLPaths) > 0 {
+		return fmt.Errorf("json_paths and yaml_paths can't be used together")
+	}
+	var err error
+	if r.jsonPaths, err = compilePaths("json", r.JSONPaths); err != nil {
+		return err
+	}
+	r.yamlPaths, err = compilePaths("yaml", r.YAMLPaths)
+	return err
+}
+
+func compilePaths(kind string, paths []string) ([][]string, error) {
+	compiled := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		segments := splitPath(path)
+		if len(segments) == 0 {
+			return nil, fmt.Errorf("invalid %s path %q", kind, path)
+		}
+		compiled = append(compiled, segments)
+	}
+	return compiled, nil
+}
+
+// paths returns the path rules, and how the scalars they apply to are found
+func (r *Rules) paths() ([][]string, func(contents []byte) ([]scalar, error)) {
+	if len(r.yamlPaths) > 0 {
+		return r.yamlPaths, yamlScalars
+	}
+	return r.jsonPaths, jsonScalars
+}
+
+func (r *Rules) matchesKey(key string) bool {
+	for _, pattern := range r.keys {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact masks every secret in contents, returning the masked contents and the number of values
+// that were masked; format is the config_edit format key patterns are applied with
+func Redact(r *Rules, format config_edit.Format, contents []byte) ([]byte, int, error) {
+	if r == nil {
+		return contents, 0, nil
+	}
+	if r.WholeFile {
+		if len(bytes.TrimSpace(contents)) == 0 {
+			return contents, 0, nil
+		}
+		return []byte(Placeholder + "\n"), 1, nil
+	}
+
+	count := 0
+	var err error
+	if len(r.keys) > 0 {
+		contents, err = config_edit.Rewrite(format, contents, func(_ string, key string, value string) (string, bool) {
+			if value == "" || value == Placeholder || !r.matchesKey(key) {
+				return "", false
+			}
+			count++
+			return Placeholder, true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if patterns, scalarsOf := r.paths(); len(patterns) > 0 {
+		scalars, err := scalarsOf(contents)
+		if err != nil {
+			return nil, 0, err
+		}
+		contents, err = rewritePaths(contents, scalars, patterns, func(value scalar) ([]byte, bool, error) {
+			if value.value == nil || value.value == "" || value.value == Placeholder {
+				return nil, false, nil
+			}
+			count++
+			return quotedPlaceholder, true, nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return contents, count, nil
+}
+
+// Restore replaces the placeholders of a submitted config file with the secrets they stand for in
+// the current version of the file, so a redacted config can be edited and written back
+func Restore(r *Rules, format config_edit.Format, submitted []byte, current []byte) ([]byte, error) {
+	if r == nil {
+		return submitted, nil
+	}
+	if r.WholeFile {
+		if strings.TrimSpace(string(submitted)) != Placeholder {
+			return submitted, nil
+		}
+		if len(bytes.TrimSpace(current)) == 0 {
+			return nil, fmt.Errorf("there is no existing value to keep in place of %s", Placeholder)
+		}
+		return current, nil
+	}
+
+	var err error
+	if len(r.keys) > 0 && bytes.Contains(submitted, []byte(Placeholder)) {
+		secrets := make(map[string]string)
+		if _, err := config_edit.Rewrite(format, current, func(section string, key string, value string) (string, bool) {
+			secrets[section+"\x00"+key] = value
+			return "", false
+		}); err != nil {
+			return nil, err
+		}
+		var missing error
+		submitted, err = config_edit.Rewrite(format, submitted, func(section string, key string, value string) (string, bool) {
+			if value != Placeholder || !r.matchesKey(key) {
+				return "", false
+			}
+			secret, ok := secrets[section+"\x00"+key]
+			if !ok && missing == nil {
+				missing = fmt.Errorf("there is no existing value of %s to keep in place of %s", key, Placeholder)
+			}
+			return secret, ok
+		})
+		if err != nil {
+			return nil, err
+		}
+		if missing != nil {
+			return nil, missing
+		}
+	}
+	if patterns, scalarsOf := r.paths(); len(patterns) > 0 && bytes.Contains(submitted, []byte(Placeholder)) {
+		// a current file that can't be parsed simply has no secrets to keep
+		secrets := make(map[string][]byte)
+		if scalars, err := scalarsOf(current); err == nil {
+			for _, value := range scalars {
+				secrets[strings.Join(value.path, "\x00")] = current[value.start:value.end]
+			}
+		}
+		scalars, err := scalarsOf(submitted)
+		if err != nil {
+			return nil, err
+		}
+		// the secrets are spliced in as they are written on disk, so that writing back an
+		// unchanged file leaves it byte for byte the same
+		submitted, err = rewritePaths(submitted, scalars, patterns, func(value scalar) ([]byte, bool, error) {
+			if value.value != Placeholder {
+				return nil, false, nil
+			}
+			secret, ok := secrets[strings.Join(value.path, "\x00")]
+			if !ok {
+				return nil, false, fmt.Errorf("there is no existing value of %s to keep in place of %s", strings.Join(value.path, "."), Placeholder)
+			}
+			return secret, true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return submitted, nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/supabase/supabase-admin-api/api/config_history"
+	"github.com/supabase/supabase-admin-api/api/config_redaction"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+// RevealSecretsScope must be among the token's scopes for config secrets to be read in plaintext
+const RevealSecretsScope = "config:reveal"
+
+const revealSecretsContextKey = contextKey("reveal_secrets")
+
+// hasScope reports whether the token's `scope` claim, either a space separated string or a list,
+// grants the given scope
+func hasScope(r *http.Request, scope string) bool {
+	var scopes []string
+	switch claim := getClaims(r)["scope"].(type) {
+	case string:
+		scopes = strings.Fields(claim)
+	case []interface{}:
+		for _, s := range claim {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretRevealingHandler handles the `reveal` query parameter of config endpoints: secrets are
+// redacted unless `?reveal=true` is passed by a token with the reveal scope, and tokens without it
+// get a 403 for asking
+func (a *API) SecretRevealingHandler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		reveal := false
+		if value := r.URL.Query().Get("reveal"); value != "" {
+			var err error
+			if reveal, err = strconv.ParseBool(value); err != nil {
+				if err := sendJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid reveal value %q", value)); err != nil {
+					handleError(err, w, r)
+				}
+				return
+			}
+		}
+		if reveal && !hasScope(r, RevealSecretsScope) {
+			if err := sendJSON(w, http.StatusForbidden, fmt.Sprintf("revealing secrets requires the %s scope", RevealSecretsScope)); err != nil {
+				handleError(err, w, r)
+			}
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), revealSecretsContextKey, reveal)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// redactConfig masks the secrets of an application's config file, unless the request is allowed
+// to and asked to see them; it returns the number of masked values
+func redactConfig(r *http.Request, app *registry.Application, contents []byte) ([]byte, int, error) {
+	if reveal, _ := r.Context().Value(revealSecretsContextKey).(bool); reveal {
+		return contents, 0, nil
+	}
+	return config_redaction.Redact(app.Redact, app.Format, contents)
+}
+
+// restoreSecrets puts the secrets currently on disk back in place of the placeholders of a config
+// file that was read redacted
+func restoreSecrets(app *registry.Application, submitted []byte, current []byte) ([]byte, error) {
+	return config_redaction.Restore(app.Redact, app.Format, submitted, current)
+}
+
+// redactedDiff renders a unified diff between two versions of a config file with their secrets
+// masked; changes to secret values only show up when secrets are revealed
+func redactedDiff(r *http.Request, app *registry.Application, fromName string, from []byte, toName string, to []byte) (string, error) {
+	from, _, err := redactConfig(r, app, from)
+	if err != nil {
+		return "", err
+	}
+	to, _, err = redactConfig(r, app, to)
+	if err != nil {
+		return "", err
+	}
+	return config_history.UnifiedDiff(fromName, from, toName, to)
+}
@@ -0,0 +1,58 @@
+package config_validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvLine parses a single KEY=VALUE line of a dotenv / systemd EnvironmentFile
+func ParseEnvLine(line string) (Line, error) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return Line{Kind: Blank}, nil
+	}
+	if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+		return Line{Kind: Comment}, nil
+	}
+	trimmed = strings.TrimPrefix(trimmed, "export ")
+
+	idx := strings.Index(trimmed, "=")
+	if idx == -1 {
+		return Line{}, fmt.Errorf("expected KEY=VALUE")
+	}
+	key := strings.TrimSpace(trimmed[:idx])
+	if !envKeyPattern.MatchString(key) {
+		return Line{}, fmt.Errorf("invalid variable name %q", key)
+	}
+	value, err := unquoteEnvValue(strings.TrimSpace(trimmed[idx+1:]))
+	if err != nil {
+		return Line{}, fmt.Errorf("%s: %s", key, err)
+	}
+	return Line{Kind: Entry, Key: key, Value: value}, nil
+}
+
+func unquoteEnvValue(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		return value, nil
+	}
+	if len(value) < 2 || value[len(value)-1] != quote {
+		return "", fmt.Errorf("unterminated %c quoted value", quote)
+	}
+	inner := value[1 : len(value)-1]
+	if quote == '"' {
+		inner = strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n").Replace(inner)
+	}
+	return inner, nil
+}
+
+// ValidateEnv checks that every non-comment line is a well-formed KEY=VALUE assignment
+func ValidateEnv(contents []byte) []LineError {
+	return validateLines(contents, ParseEnvLine)
+}
@@ -0,0 +1,46 @@
+package config_validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseIniLine parses a single line of a pgbouncer style INI file
+func ParseIniLine(line string) (Line, error) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return Line{Kind: Blank}, nil
+	}
+	if strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+		return Line{Kind: Comment}, nil
+	}
+	if strings.HasPrefix(trimmed, "%include") {
+		path := strings.TrimSpace(strings.TrimPrefix(trimmed, "%include"))
+		if path == "" {
+			return Line{}, fmt.Errorf("%%include requires a file name")
+		}
+		return Line{Kind: Include, Value: path}, nil
+	}
+	if strings.HasPrefix(trimmed, "[") {
+		if !strings.HasSuffix(trimmed, "]") || len(trimmed) < 3 {
+			return Line{}, fmt.Errorf("malformed section header %q", trimmed)
+		}
+		return Line{Kind: Section, Key: strings.TrimSpace(trimmed[1 : len(trimmed)-1])}, nil
+	}
+
+	idx := strings.Index(trimmed, "=")
+	if idx == -1 {
+		return Line{}, fmt.Errorf("expected key = value")
+	}
+	key := strings.TrimSpace(trimmed[:idx])
+	if key == "" || strings.ContainsAny(key, " \t[]") {
+		return Line{}, fmt.Errorf("invalid key %q", key)
+	}
+	return Line{Kind: Entry, Key: key, Value: strings.TrimSpace(trimmed[idx+1:])}, nil
+}
+
+// ValidateIni checks that the file is made up of section headers, %include directives and
+// key = value assignments
+func ValidateIni(contents []byte) []LineError {
+	return validateLines(contents, ParseIniLine)
+}
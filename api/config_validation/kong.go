@@ -0,0 +1,153 @@
+package config_validation
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+): (.*)`)
+
+var kongFormatVersions = map[string]bool{
+	"1.1": true,
+	"2.1": true,
+	"3.0": true,
+}
+
+var kongTopLevelKeys = map[string]bool{
+	"_format_version":       true,
+	"_transform":            true,
+	"_comment":              true,
+	"_info":                 true,
+	"_workspace":            true,
+	"services":              true,
+	"routes":                true,
+	"consumers":             true,
+	"plugins":               true,
+	"upstreams":             true,
+	"certificates":          true,
+	"ca_certificates":       true,
+	"snis":                  true,
+	"vaults":                true,
+	"acls":                  true,
+	"basicauth_credentials": true,
+	"keyauth_credentials":   true,
+	"hmacauth_credentials":  true,
+	"jwt_secrets":           true,
+	"oauth2_credentials":    true,
+}
+
+var kongRouteMatchers = []string{"paths", "hosts", "methods", "headers", "snis", "sources", "destinations"}
+
+// ValidateKong checks that the file is valid YAML and has the shape of a kong declarative config
+func ValidateKong(contents []byte) []LineError {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		if match := yamlErrorLinePattern.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return []LineError{{Line: line, Message: match[2]}}
+		}
+		return []LineError{{Line: 1, Message: err.Error()}}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return []LineError{{Line: 1, Message: "declarative config must be a YAML mapping"}}
+	}
+	root := doc.Content[0]
+
+	errs := make([]LineError, 0)
+	if version := mappingValue(root, "_format_version"); version == nil {
+		errs = append(errs, LineError{Line: root.Line, Message: "missing _format_version"})
+	} else if !kongFormatVersions[version.Value] {
+		errs = append(errs, LineError{Line: version.Line, Message: fmt.Sprintf("unsupported _format_version %q", version.Value)})
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if !kongTopLevelKeys[key.Value] {
+			errs = append(errs, LineError{Line: key.Line, Message: fmt.Sprintf("unknown top-level key %q", key.Value)})
+			continue
+		}
+		switch key.Value {
+		case "services":
+			errs = append(errs, eachEntity(value, "services", validateKongService)...)
+		case "routes":
+			errs = append(errs, eachEntity(value, "routes", validateKongRoute)...)
+		case "consumers":
+			errs = append(errs, eachEntity(value, "consumers", validateKongConsumer)...)
+		case "plugins":
+			errs = append(errs, eachEntity(value, "plugins", validateKongPlugin)...)
+		}
+	}
+	return errs
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func eachEntity(node *yaml.Node, name string, validate func(*yaml.Node) []LineError) []LineError {
+	if node.Kind != yaml.SequenceNode {
+		return []LineError{{Line: node.Line, Message: fmt.Sprintf("%s must be a list", name)}}
+	}
+	errs := make([]LineError, 0)
+	for _, entity := range node.Content {
+		if entity.Kind != yaml.MappingNode {
+			errs = append(errs, LineError{Line: entity.Line, Message: fmt.Sprintf("each entry of %s must be a mapping", name)})
+			continue
+		}
+		errs = append(errs, validate(entity)...)
+	}
+	return errs
+}
+
+func validateKongService(service *yaml.Node) []LineError {
+	errs := make([]LineError, 0)
+	if mappingValue(service, "url") == nil && mappingValue(service, "host") == nil {
+		errs = append(errs, LineError{Line: service.Line, Message: "service requires either url or host"})
+	}
+	if routes := mappingValue(service, "routes"); routes != nil {
+		errs = append(errs, eachEntity(routes, "routes", validateKongRoute)...)
+	}
+	if plugins := mappingValue(service, "plugins"); plugins != nil {
+		errs = append(errs, eachEntity(plugins, "plugins", validateKongPlugin)...)
+	}
+	return errs
+}
+
+func validateKongRoute(route *yaml.Node) []LineError {
+	errs := make([]LineError, 0)
+	matched := false
+	for _, matcher := range kongRouteMatchers {
+		if mappingValue(route, matcher) != nil {
+			matched = true
+		}
+	}
+	if !matched {
+		errs = append(errs, LineError{Line: route.Line, Message: "route requires at least one of paths, hosts, methods, headers, snis, sources or destinations"})
+	}
+	if plugins := mappingValue(route, "plugins"); plugins != nil {
+		errs = append(errs, eachEntity(plugins, "plugins", validateKongPlugin)...)
+	}
+	return errs
+}
+
+func validateKongConsumer(consumer *yaml.Node) []LineError {
+	if mappingValue(consumer, "username") == nil && mappingValue(consumer, "custom_id") == nil {
+		return []LineError{{Line: consumer.Line, Message: "consumer requires either username or custom_id"}}
+	}
+	return nil
+}
+
+func validateKongPlugin(plugin *yaml.Node) []LineError {
+	if name := mappingValue(plugin, "name"); name == nil || name.Value == "" {
+		return []LineError{{Line: plugin.Line, Message: "plugin requires a name"}}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package config_validation
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//go:embed postgresql_gucs.txt
+var knownGucList string
+
+var knownGucs = func() map[string]bool {
+	gucs := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(knownGucList))
+	for scanner.Scan() {
+		if guc := strings.TrimSpace(scanner.Text()); guc != "" {
+			gucs[strings.ToLower(guc)] = true
+		}
+	}
+	return gucs
+}()
+
+var gucNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)?$`)
+
+var includeDirectives = map[string]bool{
+	"include":           true,
+	"include_if_exists": true,
+	"include_dir":       true,
+}
+
+// IsKnownGuc reports whether name is a core postgres setting or a custom (extension.setting) one
+func IsKnownGuc(name string) bool {
+	return strings.Contains(name, ".") || knownGucs[strings.ToLower(name)]
+}
+
+// ParsePostgresqlConfLine parses a single `name = value` line of a postgresql.conf file,
+// following the lexical rules of the postgres config file parser
+func ParsePostgresqlConfLine(line string) (Line, error) {
+	rest := strings.TrimSpace(line)
+	if rest == "" {
+		return Line{Kind: Blank}, nil
+	}
+	if strings.HasPrefix(rest, "#") {
+		return Line{Kind: Comment}, nil
+	}
+
+	nameEnd := strings.IndexAny(rest, " \t=")
+	if nameEnd == -1 {
+		return Line{}, fmt.Errorf("setting %q has no value", rest)
+	}
+	name := rest[:nameEnd]
+	if !gucNamePattern.MatchString(name) {
+		return Line{}, fmt.Errorf("invalid setting name %q", name)
+	}
+	rest = strings.TrimLeft(rest[nameEnd:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	value, rest, err := parsePostgresqlConfValue(rest)
+	if err != nil {
+		return Line{}, fmt.Errorf("%s: %s", name, err)
+	}
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return Line{}, fmt.Errorf("%s: unexpected trailing content %q", name, rest)
+	}
+
+	if includeDirectives[strings.ToLower(name)] {
+		return Line{Kind: Include, Key: name, Value: value, Comment: rest}, nil
+	}
+	return Line{Kind: Entry, Key: name, Value: value, Comment: rest}, nil
+}
+
+func parsePostgresqlConfValue(rest string) (string, string, error) {
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		return "", "", fmt.Errorf("missing value")
+	}
+	if rest[0] != '\'' {
+		end := strings.IndexAny(rest, " \t#")
+		if end == -1 {
+			end = len(rest)
+		}
+		return rest[:end], rest[end:], nil
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(rest); i++ {
+		switch {
+		case rest[i] == '\\' && i+1 < len(rest):
+			value.WriteByte(rest[i+1])
+			i++
+		case rest[i] == '\'' && i+1 < len(rest) && rest[i+1] == '\'':
+			value.WriteByte('\'')
+			i++
+		case rest[i] == '\'':
+			return value.String(), rest[i+1:], nil
+		default:
+			value.WriteByte(rest[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted string")
+}
+
+// ValidatePostgresqlConf checks the syntax of every line and that every setting is known to postgres
+func ValidatePostgresqlConf(contents []byte) []LineError {
+	errs := make([]LineError, 0)
+	for i, text := range splitLines(contents) {
+		line, err := ParsePostgresqlConfLine(text)
+		if err != nil {
+			errs = append(errs, LineError{Line: i + 1, Message: err.Error()})
+			continue
+		}
+		if line.Kind == Entry && !IsKnownGuc(line.Key) {
+			errs = append(errs, LineError{Line: i + 1, Message: fmt.Sprintf("unrecognized configuration parameter %q", line.Key)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+package config_validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var postgrestKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// ParsePostgrestLine parses a single line of PostgREST's config file: `key = value`, where strings
+// are double quoted and numbers and booleans bare, and `#` starts a comment
+func ParsePostgrestLine(line string) (Line, error) {
+	rest := strings.TrimSpace(line)
+	if rest == "" {
+		return Line{Kind: Blank}, nil
+	}
+	if strings.HasPrefix(rest, "#") {
+		return Line{Kind: Comment}, nil
+	}
+
+	idx := strings.Index(rest, "=")
+	if idx == -1 {
+		return Line{}, fmt.Errorf("expected key = value")
+	}
+	key := strings.TrimSpace(rest[:idx])
+	if !postgrestKeyPattern.MatchString(key) {
+		return Line{}, fmt.Errorf("invalid key %q", key)
+	}
+	rest = strings.TrimSpace(rest[idx+1:])
+
+	var value string
+	if strings.HasPrefix(rest, `"`) {
+		end := -1
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+			} else if rest[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end == -1 {
+			return Line{}, fmt.Errorf("%s: unterminated quoted value", key)
+		}
+		value = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(rest[1:end])
+		rest = strings.TrimSpace(rest[end+1:])
+	} else {
+		end := strings.Index(rest, "#")
+		if end == -1 {
+			end = len(rest)
+		}
+		value = strings.TrimSpace(rest[:end])
+		rest = rest[end:]
+		if value == "" {
+			return Line{}, fmt.Errorf("%s: missing value", key)
+		}
+	}
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return Line{}, fmt.Errorf("%s: unexpected trailing content %q", key, rest)
+	}
+	return Line{Kind: Entry, Key: key, Value: value, Comment: rest}, nil
+}
@@ -0,0 +1,98 @@
+package config_validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LineError describes a problem found on a given (1-based) line of a config file
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Validator checks the contents of a config file, returning every problem it finds
+type Validator func(contents []byte) []LineError
+
+const (
+	Kong       = "kong"
+	Env        = "env"
+	Postgresql = "postgresql"
+	Ini        = "ini"
+	Walg       = "walg"
+	Pgsodium   = "pgsodium"
+)
+
+var validators = map[string]Validator{
+	Kong:       ValidateKong,
+	Env:        ValidateEnv,
+	Postgresql: ValidatePostgresqlConf,
+	Ini:        ValidateIni,
+	Walg:       ValidateWalg,
+	Pgsodium:   ValidatePgsodiumKey,
+}
+
+// Get looks up a validator by name
+func Get(name string) (Validator, bool) {
+	validator, ok := validators[name]
+	return validator, ok
+}
+
+// LineKind classifies a single line of a line-oriented config format
+type LineKind int
+
+const (
+	Blank LineKind = iota
+	Comment
+	Section
+	Include
+	Entry
+)
+
+// Line is a single parsed line of a line-oriented config format
+type Line struct {
+	Kind    LineKind
+	Key     string
+	Value   string
+	Comment string
+}
+
+func splitLines(contents []byte) []string {
+	lines := strings.Split(string(contents), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+func validateLines(contents []byte, parse func(string) (Line, error)) []LineError {
+	errs := make([]LineError, 0)
+	for i, line := range splitLines(contents) {
+		if _, err := parse(line); err != nil {
+			errs = append(errs, LineError{Line: i + 1, Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+var hexKeyPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// ValidatePgsodiumKey checks that the root key is a single 32 byte, hex encoded key
+func ValidatePgsodiumKey(contents []byte) []LineError {
+	lines := splitLines(contents)
+	if len(lines) != 1 {
+		return []LineError{{Line: 1, Message: fmt.Sprintf("expected a single line holding the root key, found %d lines", len(lines))}}
+	}
+	if !hexKeyPattern.MatchString(strings.TrimSpace(lines[0])) {
+		return []LineError{{Line: 1, Message: "root key must be 64 hexadecimal characters (32 bytes)"}}
+	}
+	return nil
+}
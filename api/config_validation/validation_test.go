@@ -0,0 +1,40 @@
+package config_validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	cases := []struct {
+		name      string
+		validator Validator
+		contents  string
+		lines     []int
+	}{
+		{"env ok", ValidateEnv, "# comment\nGOTRUE_JWT_SECRET=abc\nexport API_EXTERNAL_URL=\"http://localhost\"\n\n", []int{}},
+		{"env broken", ValidateEnv, "A=1\nnot an assignment\nB='unterminated\n1BAD=x\n", []int{2, 3, 4}},
+		{"postgresql ok", ValidatePostgresqlConf, "max_connections = 100\nshared_buffers '1GB' # trailing comment\npgsodium.getkey_script = '/usr/bin/key'\ninclude_if_exists = 'extra.conf'\n", []int{}},
+		{"postgresql broken", ValidatePostgresqlConf, "max_conections = 100\nwork_mem = '4MB\nstatement_timeout\nshared_buffers = 1GB extra\n", []int{1, 2, 3, 4}},
+		{"ini ok", ValidateIni, "[pgbouncer]\n; comment\npool_mode = transaction\n%include /etc/pgbouncer/extra.ini\n", []int{}},
+		{"ini broken", ValidateIni, "[pgbouncer\nmax_client_conn\n", []int{1, 2}},
+		{"walg ok", ValidateWalg, "{\n  \"AWS_REGION\": \"us-east-1\",\n  \"WALG_COMPRESSION_METHOD\": \"lz4\"\n}\n", []int{}},
+		{"walg bad keys", ValidateWalg, "{\n  \"aws_region\": \"us-east-1\",\n  \"WALG_TAGS\": [\"a\"]\n}\n", []int{2, 3}},
+		{"walg syntax", ValidateWalg, "{\n  \"AWS_REGION\": \"us-east-1\"\n  \"WALG_S3_PREFIX\": \"s3://x\"\n}\n", []int{3}},
+		{"pgsodium ok", ValidatePgsodiumKey, "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n", []int{}},
+		{"pgsodium short", ValidatePgsodiumKey, "0123456789abcdef\n", []int{1}},
+		{"kong ok", ValidateKong, "_format_version: \"2.1\"\nservices:\n  - name: auth-v1\n    url: http://localhost:9999/\n    routes:\n      - name: auth-v1\n        paths:\n          - /auth/v1/\n    plugins:\n      - name: cors\nconsumers:\n  - username: anon\n", []int{}},
+		{"kong broken", ValidateKong, "services:\n  - name: auth-v1\n    routes:\n      - name: no-matchers\nbogus: true\n", []int{1, 2, 4, 5}},
+		{"kong syntax", ValidateKong, "_format_version: \"2.1\"\nservices:\n\t- name: a\n", []int{3}},
+	}
+
+	for _, c := range cases {
+		lines := make([]int, 0)
+		for _, err := range c.validator([]byte(c.contents)) {
+			lines = append(lines, err.Line)
+		}
+		if !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%s: expected errors on lines %v, got %v (%+v)", c.name, c.lines, lines, c.validator([]byte(c.contents)))
+		}
+	}
+}
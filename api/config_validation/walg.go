@@ -0,0 +1,63 @@
+package config_validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var walgKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// ValidateWalg checks that config.json is a flat JSON object of wal-g settings, i.e.
+// upper-case setting names mapped to scalar values
+func ValidateWalg(contents []byte) []LineError {
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	lineAt := func(offset int64) int {
+		return bytes.Count(contents[:offset], []byte("\n")) + 1
+	}
+	syntaxError := func(err error) []LineError {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return []LineError{{Line: lineAt(syntaxErr.Offset), Message: syntaxErr.Error()}}
+		}
+		return []LineError{{Line: lineAt(decoder.InputOffset()), Message: err.Error()}}
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return syntaxError(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return []LineError{{Line: 1, Message: "config must be a JSON object"}}
+	}
+
+	errs := make([]LineError, 0)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return append(errs, syntaxError(err)...)
+		}
+		key := token.(string)
+		line := lineAt(decoder.InputOffset())
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return append(errs, syntaxError(err)...)
+		}
+		if !walgKeyPattern.MatchString(key) {
+			errs = append(errs, LineError{Line: line, Message: fmt.Sprintf("invalid setting name %q", key)})
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			errs = append(errs, LineError{Line: line, Message: fmt.Sprintf("%s must be a string, number or boolean", key)})
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return append(errs, syntaxError(err)...)
+	}
+	if decoder.More() {
+		errs = append(errs, LineError{Line: lineAt(decoder.InputOffset()), Message: "unexpected content after the config object"})
+	}
+	return errs
+}
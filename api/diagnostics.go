@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/config_redaction"
+	"github.com/supabase/supabase-admin-api/api/diagnostics"
+	"github.com/supabase/supabase-admin-api/api/journal"
+	metrics "github.com/supabase/supabase-admin-api/api/metrics_endpoint"
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultDiagnosticsLogWindow is how far back the journal of each unit goes, unless since is given
+const defaultDiagnosticsLogWindow = 6 * time.Hour
+
+// diagnosticsLogLines is the most journal entries collected per unit
+const diagnosticsLogLines = 2000
+
+const walgApplication = "walg"
+
+// runDiagnosticCommand returns the output of a command, along with its stderr when it fails
+func runDiagnosticCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return stdout, errors.Wrapf(err, "%s: %s", name, message)
+		}
+		return stdout, errors.Wrap(err, name)
+	}
+	return stdout, nil
+}
+
+func marshalDiagnostics(v interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// formatJournal renders journal entries one per line, as journalctl's short-iso output does
+func formatJournal(entries []journal.Entry) []byte {
+	var b bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s <%d> %s[%d]: %s\n", entry.Timestamp.Format(time.RFC3339Nano), entry.Priority, entry.Identifier, entry.PID, entry.Message)
+	}
+	return b.Bytes()
+}
+
+// redactedAdminConfig copies the admin API config with the settings that hold secrets masked:
+// the JWT secret, the config drift webhook, and the URLs of upstream metrics sources, which
+// can carry credentials in their user info or query
+func redactedAdminConfig(config *Config) *Config {
+	redacted := *config
+	if redacted.JwtSecret != "" {
+		redacted.JwtSecret = config_redaction.Placeholder
+	}
+	if redacted.Monitoring.ConfigDrift.EventWebhook != "" {
+		redacted.Monitoring.ConfigDrift.EventWebhook = config_redaction.Placeholder
+	}
+	redacted.UpstreamMetricsSources = make([]metrics.MetricsSourceConfig, len(config.UpstreamMetricsSources))
+	for i, source := range config.UpstreamMetricsSources {
+		if source.Url != "" {
+			source.Url = config_redaction.Placeholder
+		}
+		redacted.UpstreamMetricsSources[i] = source
+	}
+	return &redacted
+}
+
+// diagnosticsCollectors lists every file of a bundle; everything that can hold a secret goes
+// through the same redaction as log and config responses. Bundles are handed on to support, so
+// nothing in them is ever revealed, whatever the token asking may read
+func (a *API) diagnosticsCollectors(since time.Time) []diagnostics.Collector {
+	collectors := []diagnostics.Collector{
+		{Name: "version.json", Collect: func(ctx context.Context) ([]byte, error) {
+			hostname, _ := os.Hostname()
+			return marshalDiagnostics(map[string]string{
+				"name":       "supabase-admin-api",
+				"version":    a.version,
+				"go_version": runtime.Version(),
+				"hostname":   hostname,
+			}, nil)
+		}},
+		{Name: "adminapi.yaml", Collect: func(ctx context.Context) ([]byte, error) {
+			data, err := yaml.Marshal(redactedAdminConfig(a.config))
+			if err != nil {
+				return nil, err
+			}
+			redacted, _ := a.logRedaction.Redact(string(data))
+			return []byte(redacted), nil
+		}},
+		{Name: "status/units.json", Collect: func(ctx context.Context) ([]byte, error) {
+			statuses := make([]*ServiceStatus, 0)
+			for _, app := range a.applications.All(registry.HasUnit) {
+				statuses = append(statuses, a.serviceStatus(ctx, app))
+			}
+			return marshalDiagnostics(statuses, nil)
+		}},
+	}
+
+	units := make(map[string]bool)
+	for _, app := range a.applications.All(registry.HasJournal) {
+		unit := app.Logs.Unit
+		if units[unit] {
+			continue
+		}
+		units[unit] = true
+		collectors = append(collectors, diagnostics.Collector{
+			Name: fmt.Sprintf("logs/%s.log", unit),
+			Collect: func(ctx context.Context) ([]byte, error) {
+				entries, err := journal.Read(ctx, journal.Query{Unit: unit, Since: &since, Priority: -1, Limit: diagnosticsLogLines})
+				if err != nil {
+					return nil, err
+				}
+				for i := range entries {
+					entries[i].Message, _ = a.logRedaction.Redact(entries[i].Message)
+				}
+				return formatJournal(entries), nil
+			},
+		})
+	}
+	for _, app := range a.applications.All(registry.HasTextLogFiles) {
+		files := app.Logs.Files
+		collectors = append(collectors, diagnostics.Collector{
+			Name: fmt.Sprintf("logs/%s.log", app.Name),
+			Collect: func(ctx context.Context) ([]byte, error) {
+				lines, err := readTextLog(files, true, diagnosticsLogLines)
+				if err != nil {
+					return nil, err
+				}
+				var b bytes.Buffer
+				for _, line := range lines {
+					line, _ = a.logRedaction.Redact(line)
+					b.WriteString(line + "\n")
+				}
+				return b.Bytes(), nil
+			},
+		})
+	}
+
+	for _, app := range a.applications.All(registry.HasConfig) {
+		app := app
+		collectors = append(collectors, diagnostics.Collector{
+			Name: fmt.Sprintf("configs/%s/%s", app.Name, filepath.Base(app.Config.Path)),
+			Collect: func(ctx context.Context) ([]byte, error) {
+				// without rules there's no telling which values are secrets, so the file is left
+				// out rather than shipped as it is
+				if app.Redact == nil {
+					return nil, fmt.Errorf("left out, %s has no redaction rules", app.Name)
+				}
+				contents, err := os.ReadFile(app.Config.Path)
+				if err != nil {
+					return nil, err
+				}
+				redacted, _, err := config_redaction.Redact(app.Redact, app.Format, contents)
+				return redacted, err
+			},
+		})
+	}
+
+	collectors = append(collectors,
+		diagnostics.Collector{Name: "system/df.txt", Collect: func(ctx context.Context) ([]byte, error) {
+			return runDiagnosticCommand(ctx, "df", "-P", "-h")
+		}},
+		diagnostics.Collector{Name: "system/df-inodes.txt", Collect: func(ctx context.Context) ([]byte, error) {
+			return runDiagnosticCommand(ctx, "df", "-P", "-i")
+		}},
+		diagnostics.Collector{Name: "metrics.txt", Collect: func(ctx context.Context) ([]byte, error) {
+			families, err := a.metricsGatherer.Gather()
+			var b bytes.Buffer
+			for _, family := range families {
+				if _, writeErr := expfmt.MetricFamilyToText(&b, family); writeErr != nil {
+					return b.Bytes(), writeErr
+				}
+			}
+			// a failing collector still leaves the others' metrics
+			return b.Bytes(), err
+		}},
+		diagnostics.Collector{Name: "fail2ban.json", Collect: func(ctx context.Context) ([]byte, error) {
+			return marshalDiagnostics(a.networkBans.Status())
+		}},
+	)
+
+	if walg, ok := a.applications.Get(walgApplication); ok && registry.HasConfig(walg) {
+		collectors = append(collectors, diagnostics.Collector{Name: "walg/backup-list.json", Collect: func(ctx context.Context) ([]byte, error) {
+			return runDiagnosticCommand(ctx, "sudo", "-u", "postgres", "wal-g", "backup-list", "--detail", "--json", "--config", walg.Config.Path)
+		}})
+	}
+	return collectors
+}
+
+// GetDiagnosticsBundle streams a tar.gz of everything support usually asks for, bounded in time
+// and size; its manifest.json lists what was collected and what failed
+func (a *API) GetDiagnosticsBundle(w http.ResponseWriter, r *http.Request) error {
+	since := time.Now().Add(-defaultDiagnosticsLogWindow)
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := parseLogTime(value)
+		if err != nil {
+			return sendJSON(w, http.StatusBadRequest, err.Error())
+		}
+		since = *parsed
+	}
+	if !a.diagnosticsLock.TryLock() {
+		return sendJSON(w, http.StatusTooManyRequests, "a diagnostics bundle is already being collected")
+	}
+	defer a.diagnosticsLock.Unlock()
+
+	filename := fmt.Sprintf("diagnostics-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	manifest, err := diagnostics.Write(r.Context(), w, a.version, a.diagnosticsCollectors(since), a.diagnosticsLimits)
+	log := logrus.WithField("component", "diagnostics")
+	if err != nil {
+		// the response has started, so the client only sees a truncated bundle
+		log.WithError(err).Error("failed to write diagnostics bundle")
+		return nil
+	}
+	log.WithField("files", len(manifest.Files)).WithField("failures", len(manifest.Failures)).WithField("bytes", manifest.Bytes).Info("wrote diagnostics bundle")
+	return nil
+}
@@ -0,0 +1,196 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultTimeout = "60s"
+const DefaultCollectorTimeout = "15s"
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+// ManifestName is the file the manifest is written to, last in the bundle
+const ManifestName = "manifest.json"
+
+// Config bounds how long collecting a bundle may take and how large it may grow
+type Config struct {
+	// Timeout bounds the whole bundle; collectors that haven't started by then are skipped
+	Timeout string `yaml:"timeout" required:"false"`
+	// CollectorTimeout bounds each collector
+	CollectorTimeout string `yaml:"collector_timeout" required:"false"`
+	// MaxBytes bounds the uncompressed size of the collected files; later files are truncated
+	// or skipped once it is reached
+	MaxBytes int64 `yaml:"max_bytes" required:"false"`
+}
+
+// Limits are the parsed bounds of a Config
+type Limits struct {
+	Timeout          time.Duration
+	CollectorTimeout time.Duration
+	MaxBytes         int64
+}
+
+// Parse applies the defaults of a config and parses its durations
+func (c Config) Parse() (Limits, error) {
+	if c.Timeout == "" {
+		c.Timeout = DefaultTimeout
+	}
+	if c.CollectorTimeout == "" {
+		c.CollectorTimeout = DefaultCollectorTimeout
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = DefaultMaxBytes
+	}
+	limits := Limits{MaxBytes: c.MaxBytes}
+	var err error
+	if limits.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+		return Limits{}, errors.Wrap(err, "invalid diagnostics timeout")
+	}
+	if limits.CollectorTimeout, err = time.ParseDuration(c.CollectorTimeout); err != nil {
+		return Limits{}, errors.Wrap(err, "invalid diagnostics collector timeout")
+	}
+	if limits.Timeout <= 0 || limits.CollectorTimeout <= 0 || limits.MaxBytes < 0 {
+		return Limits{}, fmt.Errorf("diagnostics limits must be positive")
+	}
+	return limits, nil
+}
+
+// Collector produces a single file of the bundle
+type Collector struct {
+	// Name is the path of the file in the bundle
+	Name    string
+	Collect func(ctx context.Context) ([]byte, error)
+}
+
+// File records a file that made it into the bundle
+type File struct {
+	Name      string  `json:"name"`
+	Bytes     int     `json:"bytes"`
+	Truncated bool    `json:"truncated,omitempty"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// Failure records a file that couldn't be collected, or was skipped
+type Failure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Manifest describes the contents of a bundle
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Version   string    `json:"version"`
+	Seconds   float64   `json:"seconds"`
+	Bytes     int64     `json:"bytes"`
+	Files     []File    `json:"files"`
+	Failures  []Failure `json:"failures"`
+}
+
+// Write collects every file into a gzipped tarball written to w, in order, followed by the
+// manifest; collectors failing doesn't fail the bundle, they're listed in the manifest instead
+func Write(ctx context.Context, w io.Writer, version string, collectors []Collector, limits Limits) (*Manifest, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := &Manifest{
+		CreatedAt: start.UTC(),
+		Version:   version,
+		Files:     make([]File, 0, len(collectors)),
+		Failures:  make([]Failure, 0),
+	}
+	for _, collector := range collectors {
+		if ctx.Err() != nil {
+			manifest.Failures = append(manifest.Failures, Failure{collector.Name, "skipped, the bundle timed out"})
+			continue
+		}
+		remaining := limits.MaxBytes - manifest.Bytes
+		if remaining <= 0 {
+			manifest.Failures = append(manifest.Failures, Failure{collector.Name, "skipped, the bundle is full"})
+			continue
+		}
+
+		collectStart := time.Now()
+		data, err := collect(ctx, collector, limits.CollectorTimeout)
+		if err != nil {
+			logrus.WithError(err).WithField("file", collector.Name).Warn("failed to collect diagnostics")
+			manifest.Failures = append(manifest.Failures, Failure{collector.Name, err.Error()})
+			if len(data) == 0 {
+				continue
+			}
+		}
+		file := File{Name: collector.Name, Seconds: time.Since(collectStart).Seconds()}
+		if int64(len(data)) > remaining {
+			data, file.Truncated = data[:remaining], true
+		}
+		file.Bytes = len(data)
+		if err := writeFile(tw, collector.Name, data, start); err != nil {
+			return manifest, err
+		}
+		manifest.Bytes += int64(len(data))
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	manifest.Seconds = time.Since(start).Seconds()
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeFile(tw, ManifestName, encoded, start); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// collect runs a collector under its own deadline, turning panics into failures; a collector that
+// ignores its context is abandoned when the deadline passes, so it can't hold up the bundle
+func collect(ctx context.Context, collector Collector, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- result{err: fmt.Errorf("collector panicked: %v", recovered)}
+			}
+		}()
+		data, err := collector.Collect(ctx)
+		done <- result{data, err}
+	}()
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "timed out")
+	}
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0640,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,111 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func readBundle(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(contents)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	collectors := []Collector{
+		{"ok.txt", func(ctx context.Context) ([]byte, error) { return []byte("hello"), nil }},
+		{"failed.txt", func(ctx context.Context) ([]byte, error) { return nil, errors.New("no such thing") }},
+		{"partial.txt", func(ctx context.Context) ([]byte, error) { return []byte("some"), errors.New("exit status 1") }},
+		{"panicked.txt", func(ctx context.Context) ([]byte, error) { panic("boom") }},
+		{"slow.txt", func(ctx context.Context) ([]byte, error) {
+			time.Sleep(time.Second)
+			return []byte("late"), nil
+		}},
+		{"large.txt", func(ctx context.Context) ([]byte, error) { return bytes.Repeat([]byte("x"), 100), nil }},
+		{"skipped.txt", func(ctx context.Context) ([]byte, error) { return []byte("no room"), nil }},
+	}
+	var out bytes.Buffer
+	manifest, err := Write(context.Background(), &out, "1.2.3", collectors, Limits{Timeout: 5 * time.Second, CollectorTimeout: 50 * time.Millisecond, MaxBytes: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := readBundle(t, out.Bytes())
+	if files["ok.txt"] != "hello" || files["partial.txt"] != "some" || len(files["large.txt"]) != 11 {
+		t.Fatalf("unexpected files %v", files)
+	}
+	for _, name := range []string{"failed.txt", "panicked.txt", "slow.txt", "skipped.txt"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("expected %s to be left out", name)
+		}
+	}
+
+	written := &Manifest{}
+	if err := json.Unmarshal([]byte(files[ManifestName]), written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Version != "1.2.3" || written.Bytes != 20 || len(written.Files) != 3 || !written.Files[2].Truncated {
+		t.Errorf("unexpected manifest %+v", written)
+	}
+	failed := make(map[string]string)
+	for _, failure := range manifest.Failures {
+		failed[failure.Name] = failure.Error
+	}
+	expected := map[string]string{
+		"failed.txt":   "no such thing",
+		"partial.txt":  "exit status 1",
+		"panicked.txt": "collector panicked: boom",
+		"slow.txt":     "timed out: context deadline exceeded",
+		"skipped.txt":  "skipped, the bundle is full",
+	}
+	for name, message := range expected {
+		if failed[name] != message {
+			t.Errorf("expected %s to fail with %q, got %q", name, message, failed[name])
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	collectors := []Collector{
+		{"slow.txt", func(ctx context.Context) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}},
+		{"next.txt", func(ctx context.Context) ([]byte, error) { return []byte("never"), nil }},
+	}
+	var out bytes.Buffer
+	manifest, err := Write(context.Background(), &out, "", collectors, Limits{Timeout: 50 * time.Millisecond, CollectorTimeout: time.Minute, MaxBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Failures) != 2 || manifest.Failures[1].Error != "skipped, the bundle timed out" {
+		t.Fatalf("expected the bundle to stop at its deadline, got %+v", manifest.Failures)
+	}
+	if _, ok := readBundle(t, out.Bytes())[ManifestName]; !ok {
+		t.Fatal("expected the manifest to be written anyway")
+	}
+}
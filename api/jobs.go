@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+)
+
+const DefaultJobsDir = "/var/lib/adminapi/jobs"
+const DefaultJobsRetention = 100
+
+// ListJobs returns every retained job, newest first
+func (a *API) ListJobs(w http.ResponseWriter, r *http.Request) error {
+	return sendJSON(w, http.StatusOK, a.jobs.List())
+}
+
+// GetJob returns a single job, along with the output it has produced so far
+func (a *API) GetJob(w http.ResponseWriter, r *http.Request) error {
+	job, err := a.jobs.Get(chi.URLParam(r, "id"))
+	if err == jobs.ErrJobNotFound {
+		return sendJSON(w, http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, job)
+}
+
+// CancelJob stops a pending or running job
+func (a *API) CancelJob(w http.ResponseWriter, r *http.Request) error {
+	job, err := a.jobs.Cancel(chi.URLParam(r, "id"))
+	switch err {
+	case nil:
+		return sendJSON(w, http.StatusOK, job)
+	case jobs.ErrJobNotFound:
+		return sendJSON(w, http.StatusNotFound, err.Error())
+	case jobs.ErrJobFinished:
+		return sendJSON(w, http.StatusConflict, err.Error())
+	default:
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,411 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type State = string
+
+const (
+	Pending   State = "pending"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+	// Interrupted jobs were running when the admin API stopped, so their outcome is unknown
+	Interrupted State = "interrupted"
+)
+
+// OutputLimit is how much of a job's output is kept; older output is dropped first
+const OutputLimit = 64 * 1024
+
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobFinished = errors.New("job has already finished")
+
+// Job is a long-running operation run in the background
+type Job struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Description string     `json:"description"`
+	Author      string     `json:"author,omitempty"`
+	State       State      `json:"state"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Output      string     `json:"output"`
+	Error       string     `json:"error,omitempty"`
+	// RestartsAdminAPI jobs take the admin API down with them, so finding one unfinished after a
+	// restart means it did its job
+	RestartsAdminAPI bool `json:"restarts_adminapi,omitempty"`
+}
+
+// Finished reports whether the job has reached a final state
+func (j *Job) Finished() bool {
+	return j.State != Pending && j.State != Running
+}
+
+// Spec describes a job to run
+type Spec struct {
+	Kind             string
+	Description      string
+	Author           string
+	Delay            time.Duration
+	RestartsAdminAPI bool
+	// Run does the work, writing anything worth keeping to output; it must stop when ctx is done
+	Run func(ctx context.Context, output io.Writer) error
+}
+
+// Manager runs jobs in the background and keeps their state on disk as <dir>/<id>.json, so it
+// survives admin API restarts
+type Manager struct {
+	dir       string
+	retention int
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	outputs map[string]*outputBuffer
+	cancels map[string]context.CancelFunc
+	done    map[string]chan struct{}
+}
+
+// NewManager loads the jobs persisted in dir, settling the ones that were still running when the
+// admin API stopped; at most retention finished jobs are kept
+func NewManager(dir string, retention int) (*Manager, error) {
+	m := &Manager{
+		dir:       dir,
+		retention: retention,
+		jobs:      make(map[string]*Job),
+		outputs:   make(map[string]*outputBuffer),
+		cancels:   make(map[string]context.CancelFunc),
+		done:      make(map[string]chan struct{}),
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "couldn't read job %s", path)
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			logrus.WithError(err).WithField("path", path).Warn("skipping unreadable job")
+			continue
+		}
+		if !job.Finished() {
+			now := time.Now().UTC()
+			job.EndedAt = &now
+			if job.RestartsAdminAPI && job.State == Running {
+				job.State = Succeeded
+			} else {
+				job.State = Interrupted
+				job.Error = "the admin API stopped before the job finished"
+			}
+			m.save(job)
+		}
+		m.jobs[job.ID] = job
+	}
+	return m, nil
+}
+
+// Submit starts a job in the background, returning it straight away
+func (m *Manager) Submit(spec Spec) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:               id,
+		Kind:             spec.Kind,
+		Description:      spec.Description,
+		Author:           spec.Author,
+		State:            Pending,
+		CreatedAt:        time.Now().UTC(),
+		RestartsAdminAPI: spec.RestartsAdminAPI,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	output := &outputBuffer{limit: OutputLimit}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	m.outputs[id] = output
+	m.cancels[id] = cancel
+	m.done[id] = make(chan struct{})
+	m.save(job)
+	snapshot := m.snapshot(job)
+	m.mu.Unlock()
+
+	go m.run(ctx, job, spec, output)
+	return snapshot, nil
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, spec Spec, output *outputBuffer) {
+	log := logrus.WithField("job", job.ID).WithField("kind", job.Kind)
+
+	if spec.Delay > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(spec.Delay):
+		}
+	}
+
+	var err error
+	if ctx.Err() == nil {
+		m.mu.Lock()
+		started := time.Now().UTC()
+		job.StartedAt = &started
+		job.State = Running
+		m.save(job)
+		m.mu.Unlock()
+
+		log.Infof("running %s", job.Description)
+		err = spec.Run(ctx, output)
+	} else {
+		err = ctx.Err()
+	}
+
+	m.mu.Lock()
+	ended := time.Now().UTC()
+	job.EndedAt = &ended
+	job.Output = output.String()
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// a job that finished regardless of being cancelled keeps its outcome
+		job.State = Cancelled
+	case err == nil:
+		job.State = Succeeded
+		job.ExitCode = &exitCode
+	default:
+		job.State = Failed
+		job.Error = err.Error()
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+			job.ExitCode = &exitCode
+		}
+	}
+	m.save(job)
+	m.cancels[job.ID]()
+	delete(m.cancels, job.ID)
+	delete(m.outputs, job.ID)
+	close(m.done[job.ID])
+	delete(m.done, job.ID)
+	m.prune()
+	m.mu.Unlock()
+
+	if job.State == Failed {
+		log.WithField("output", job.Output).WithError(err).Warnf("%s failed", job.Description)
+	} else {
+		log.Infof("%s %s", job.Description, job.State)
+	}
+}
+
+// Get returns a single job
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return m.snapshot(job), nil
+}
+
+// List returns every retained job, newest first
+func (m *Manager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, m.snapshot(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Cancel stops a pending or running job, killing whatever it is running
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrJobNotFound
+	}
+	cancel, running := m.cancels[id]
+	done := m.done[id]
+	m.mu.Unlock()
+	if !running {
+		return nil, ErrJobFinished
+	}
+
+	cancel()
+	<-done
+	return m.Get(job.ID)
+}
+
+// Wait blocks until the job has finished or ctx is done, returning the job as of then
+func (m *Manager) Wait(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	_, ok := m.jobs[id]
+	done, running := m.done[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	return m.Get(id)
+}
+
+// snapshot copies a job, with the output captured so far if it is still running
+func (m *Manager) snapshot(job *Job) *Job {
+	copied := *job
+	if output, ok := m.outputs[job.ID]; ok {
+		copied.Output = output.String()
+	}
+	return &copied
+}
+
+// prune forgets the oldest finished jobs beyond the retention limit
+func (m *Manager) prune() {
+	if m.retention <= 0 {
+		return
+	}
+	finished := make([]*Job, 0)
+	for _, job := range m.jobs {
+		if job.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= m.retention {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+	for _, job := range finished[:len(finished)-m.retention] {
+		delete(m.jobs, job.ID)
+		if err := os.Remove(m.path(job.ID)); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("job", job.ID).Warn("failed to remove pruned job")
+		}
+	}
+}
+
+func (m *Manager) path(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+// save persists a job; failing to do so only costs its history, so it is logged rather than
+// failing the job
+func (m *Manager) save(job *Job) {
+	err := func() error {
+		if err := os.MkdirAll(m.dir, 0750); err != nil {
+			return err
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		tmpPath := m.path(job.ID) + ".tmp"
+		if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, m.path(job.ID))
+	}()
+	if err != nil {
+		logrus.WithError(err).WithField("job", job.ID).Warn("failed to persist job")
+	}
+}
+
+func newID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// RunCommand runs a command as part of a job, capturing its stdout and stderr as the job's output.
+// The command runs in a process group of its own, killed as a whole when ctx is cancelled, so that
+// whatever it started, e.g. the command behind sudo, doesn't outlive it
+func RunCommand(ctx context.Context, output io.Writer, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return pkgerrors.Wrapf(err, "%s %s", name, strings.Join(args, " "))
+	}
+
+	exited := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-ctx.Done():
+			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				logrus.WithError(err).WithField("command", name).Warn("couldn't kill the process group of a cancelled command")
+			}
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	<-killed
+	if err != nil {
+		if ctx.Err() != nil {
+			return pkgerrors.Wrapf(ctx.Err(), "%s %s", name, strings.Join(args, " "))
+		}
+		return pkgerrors.Wrapf(err, "%s %s", name, strings.Join(args, " "))
+	}
+	return nil
+}
+
+// outputBuffer keeps the last limit bytes written to it
+type outputBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
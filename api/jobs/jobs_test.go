@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, m *Manager, id string) *Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := m.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Finished() {
+		t.Fatalf("job %s didn't finish in time", id)
+	}
+	return job
+}
+
+func TestJobOutcomes(t *testing.T) {
+	m, err := NewManager(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded, err := m.Submit(Spec{Kind: "test", Run: func(ctx context.Context, output io.Writer) error {
+		return RunCommand(ctx, output, "sh", "-c", "echo hello")
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFor(t, m, succeeded.ID); job.State != Succeeded || *job.ExitCode != 0 || job.Output != "hello\n" || job.StartedAt == nil || job.EndedAt == nil {
+		t.Fatalf("expected the job to succeed, got %+v", job)
+	}
+
+	failed, _ := m.Submit(Spec{Kind: "test", Run: func(ctx context.Context, output io.Writer) error {
+		return RunCommand(ctx, output, "sh", "-c", "echo oops >&2; exit 3")
+	}})
+	if job := waitFor(t, m, failed.ID); job.State != Failed || job.ExitCode == nil || *job.ExitCode != 3 || job.Output != "oops\n" {
+		t.Fatalf("expected the job to fail with exit code 3, got %+v", job)
+	}
+
+	cancelled, _ := m.Submit(Spec{Kind: "test", Run: func(ctx context.Context, output io.Writer) error {
+		// the shell's child holds on to the output, so only killing the whole group ends the job
+		return RunCommand(ctx, output, "sh", "-c", "sleep 30 & wait")
+	}})
+	for {
+		if job, _ := m.Get(cancelled.ID); job.State == Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	started := time.Now()
+	if job, err := m.Cancel(cancelled.ID); err != nil || job.State != Cancelled {
+		t.Fatalf("expected the job to be cancelled, got %+v %v", job, err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected the command and its children to be killed, took %s", elapsed)
+	}
+	if _, err := m.Cancel(cancelled.ID); err != ErrJobFinished {
+		t.Fatalf("expected cancelling a finished job to fail, got %v", err)
+	}
+
+	if jobs := m.List(); len(jobs) != 3 || jobs[0].ID != cancelled.ID {
+		t.Fatalf("expected three jobs, newest first, got %+v", jobs)
+	}
+}
+
+func TestJobFinishingDespiteCancellation(t *testing.T) {
+	m, err := NewManager(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := make(chan struct{})
+	job, _ := m.Submit(Spec{Kind: "test", Run: func(ctx context.Context, output io.Writer) error {
+		close(running)
+		<-ctx.Done()
+		return nil
+	}})
+	<-running
+	if job, err := m.Cancel(job.ID); err != nil || job.State != Succeeded {
+		t.Fatalf("expected a job that succeeded regardless of being cancelled to have succeeded, got %+v %v", job, err)
+	}
+}
+
+func TestJobsSurviveRestarts(t *testing.T) {
+	dir := t.TempDir()
+	for id, job := range map[string]Job{
+		"restart": {ID: "restart", State: Running, RestartsAdminAPI: true},
+		"backup":  {ID: "backup", State: Running},
+		"done":    {ID: "done", State: Succeeded},
+	} {
+		data, _ := json.Marshal(job)
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := NewManager(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, state := range map[string]State{"restart": Succeeded, "backup": Interrupted, "done": Succeeded} {
+		if job, err := m.Get(id); err != nil || job.State != state {
+			t.Fatalf("expected %s to be %s, got %+v %v", id, state, job, err)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "backup.json"))
+	if job := (&Job{}); json.Unmarshal(data, job) != nil || job.State != Interrupted {
+		t.Fatalf("expected the settled state to be persisted, got %s", data)
+	}
+}
+
+func TestJobRetention(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := NewManager(dir, 2)
+	for i := 0; i < 4; i++ {
+		job, _ := m.Submit(Spec{Kind: "test", Description: fmt.Sprintf("job %d", i), Run: func(context.Context, io.Writer) error { return nil }})
+		waitFor(t, m, job.ID)
+	}
+	if jobs := m.List(); len(jobs) != 2 || jobs[0].Description != "job 3" {
+		t.Fatalf("expected the two newest jobs to be kept, got %+v", jobs)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 2 {
+		t.Fatalf("expected pruned jobs to be removed from disk, got %v", files)
+	}
+}
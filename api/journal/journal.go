@@ -0,0 +1,197 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultLimit = 100
+const MaxLimit = 1000
+
+// priorities are the syslog priority names journalctl understands, by level
+var priorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Query selects journal entries of a unit
+type Query struct {
+	Unit  string
+	Since *time.Time
+	Until *time.Time
+	// Priority is the least important level to return, 0 (emerg) to 7 (debug), or -1 for all
+	Priority int
+	// Grep is a regular expression messages have to match
+	Grep  string
+	Limit int
+	// Cursor continues a previous query, returning the entries after the one it points at
+	Cursor string
+}
+
+// Entry is a single journal entry
+type Entry struct {
+	Cursor     string    `json:"cursor"`
+	Timestamp  time.Time `json:"timestamp"`
+	Priority   int       `json:"priority"`
+	PID        int       `json:"pid,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	Message    string    `json:"message"`
+	// Redacted is the number of secrets masked in the message
+	Redacted int `json:"redacted,omitempty"`
+}
+
+// ParsePriority reads a priority given either by level or by name
+func ParsePriority(value string) (int, error) {
+	if level, err := strconv.Atoi(value); err == nil && level >= 0 && level < len(priorities) {
+		return level, nil
+	}
+	for level, name := range priorities {
+		if strings.EqualFold(value, name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, expected 0-7 or one of %s", value, strings.Join(priorities, ", "))
+}
+
+// args builds the journalctl arguments for a query; without a cursor or since, the latest entries
+// are returned, otherwise the ones right after that point
+func (q *Query) args() []string {
+	args := []string{"-u", q.Unit, "-o", "json", "--no-pager"}
+	if q.Limit >= 0 {
+		args = append(args, "-n", strconv.Itoa(q.Limit))
+	}
+	if q.Since != nil {
+		args = append(args, "--since", fmt.Sprintf("@%d", q.Since.Unix()))
+	}
+	if q.Until != nil {
+		args = append(args, "--until", fmt.Sprintf("@%d", q.Until.Unix()))
+	}
+	if q.Priority >= 0 {
+		args = append(args, "-p", strconv.Itoa(q.Priority))
+	}
+	if q.Grep != "" {
+		args = append(args, "--grep", q.Grep)
+	}
+	if q.Cursor != "" {
+		args = append(args, "--after-cursor", q.Cursor)
+	}
+	return args
+}
+
+// Read runs a query against the journal, returning its entries oldest first
+func Read(ctx context.Context, query Query) ([]Entry, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "journalctl", query.args()...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		// journalctl exits with 1 when --grep matches nothing
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && query.Grep != "" && len(stdout) == 0 && stderr.Len() == 0 {
+			return []Entry{}, nil
+		}
+		return nil, errors.Wrapf(err, "journalctl: %s", strings.TrimSpace(stderr.String()))
+	}
+	return parseEntries(bytes.NewReader(stdout))
+}
+
+// Follow streams the entries matching a query to entries as they are written to the journal,
+// until ctx is done; a query without since or a cursor starts with its last Limit entries
+func Follow(ctx context.Context, query Query, entries chan<- Entry) error {
+	if query.Since != nil || query.Cursor != "" {
+		// everything from that point on
+		query.Limit = -1
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "journalctl", append(query.args(), "--follow")...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			continue
+		}
+		select {
+		case entries <- entryFromFields(fields):
+		case <-ctx.Done():
+		}
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		// journalctl was killed because the follower went away, which is how following ends
+		return nil
+	}
+	return errors.Wrapf(err, "journalctl: %s", strings.TrimSpace(stderr.String()))
+}
+
+// parseEntries reads the output of `journalctl -o json`, one JSON object per line
+func parseEntries(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(r)
+	// entries hold at most a few KiB of message, but the journal allows far more
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, errors.Wrap(err, "couldn't parse journal entry")
+		}
+		entries = append(entries, entryFromFields(fields))
+	}
+	return entries, scanner.Err()
+}
+
+func entryFromFields(fields map[string]interface{}) Entry {
+	entry := Entry{Priority: -1}
+	entry.Cursor = stringField(fields["__CURSOR"])
+	if usec, err := strconv.ParseInt(stringField(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		entry.Timestamp = time.UnixMicro(usec).UTC()
+	}
+	if priority, err := strconv.Atoi(stringField(fields["PRIORITY"])); err == nil {
+		entry.Priority = priority
+	}
+	entry.PID, _ = strconv.Atoi(stringField(fields["_PID"]))
+	entry.Identifier = stringField(fields["SYSLOG_IDENTIFIER"])
+	entry.Message = stringField(fields["MESSAGE"])
+	return entry
+}
+
+// stringField reads a journal field, which journalctl renders as an array of bytes when it isn't
+// valid UTF-8, and as an array of values when a field is set more than once
+func stringField(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		data := make([]byte, 0, len(v))
+		for _, b := range v {
+			n, ok := b.(float64)
+			if !ok {
+				// a repeated field; the first value will do
+				return stringField(v[0])
+			}
+			data = append(data, byte(n))
+		}
+		return string(data)
+	}
+	return ""
+}
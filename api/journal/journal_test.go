@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntries(t *testing.T) {
+	output := `{"__CURSOR":"s=1;i=1","__REALTIME_TIMESTAMP":"1654041600000000","PRIORITY":"6","_PID":"42","SYSLOG_IDENTIFIER":"kong","MESSAGE":"started"}
+
+{"__CURSOR":"s=1;i=2","__REALTIME_TIMESTAMP":"1654041601500000","PRIORITY":"3","_PID":"42","MESSAGE":[98,97,100,255]}
+{"__CURSOR":"s=1;i=3","__REALTIME_TIMESTAMP":"1654041602000000","MESSAGE":["first","second"]}
+`
+	entries, err := parseEntries(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Entry{
+		{Cursor: "s=1;i=1", Timestamp: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), Priority: 6, PID: 42, Identifier: "kong", Message: "started"},
+		{Cursor: "s=1;i=2", Timestamp: time.Date(2022, 6, 1, 0, 0, 1, 500000000, time.UTC), Priority: 3, PID: 42, Message: "bad\xff"},
+		{Cursor: "s=1;i=3", Timestamp: time.Date(2022, 6, 1, 0, 0, 2, 0, time.UTC), Priority: -1, Message: "first"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entries)
+	}
+
+	if _, err := parseEntries(strings.NewReader("not json\n")); err == nil {
+		t.Fatal("expected unparseable output to fail")
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	since := time.Unix(1654041600, 0)
+	query := Query{Unit: "kong.service", Since: &since, Priority: 3, Grep: "timeout", Limit: 10, Cursor: "s=1;i=2"}
+	expected := []string{"-u", "kong.service", "-o", "json", "--no-pager", "-n", "10", "--since", "@1654041600", "-p", "3", "--grep", "timeout", "--after-cursor", "s=1;i=2"}
+	if args := query.args(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+
+	for value, level := range map[string]int{"3": 3, "err": 3, "WARNING": 4, "debug": 7} {
+		if parsed, err := ParsePriority(value); err != nil || parsed != level {
+			t.Errorf("expected %q to be priority %d, got %d %v", value, level, parsed, err)
+		}
+	}
+	for _, value := range []string{"8", "-1", "loud"} {
+		if _, err := ParsePriority(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
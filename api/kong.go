@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const kongApplication = "kong"
+
+const defaultKongErrorsLimit = 100
+
+// GetKongErrors returns the latest upstream errors of Kong's error log, newest first, each with the
+// route and service of the request it occurred in
+func (a *API) GetKongErrors(w http.ResponseWriter, r *http.Request) error {
+	monitor := a.monitoring.KongLogs()
+	if !monitor.IsEnabled() {
+		return sendJSON(w, http.StatusNotFound, "kong log monitoring is disabled")
+	}
+
+	params := r.URL.Query()
+	since := time.Time{}
+	if value := params.Get("since"); value != "" {
+		parsed, err := parseLogTime(value)
+		if err != nil {
+			return sendJSON(w, http.StatusBadRequest, err.Error())
+		}
+		since = *parsed
+	}
+	limit := defaultKongErrorsLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return sendJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", value))
+		}
+	}
+
+	// pick up whatever was logged since the last poll
+	monitor.Poll()
+	errors := monitor.RecentErrors(since, limit)
+	redactor := a.logRedaction
+	for i := range errors {
+		errors[i].Message, _ = redactor.Redact(errors[i].Message)
+		errors[i].Request, _ = redactor.Redact(errors[i].Request)
+		errors[i].Upstream, _ = redactor.Redact(errors[i].Upstream)
+	}
+	return sendJSON(w, http.StatusOK, errors)
+}
@@ -0,0 +1,150 @@
+package kong_logs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessEntry is a request in Kong's access log
+type AccessEntry struct {
+	Time   time.Time
+	Client string
+	Method string
+	Path   string
+	Status int
+	Bytes  int64
+	// RequestTime and UpstreamTime are in seconds, set when the log format appends
+	// $request_time and $upstream_response_time to the combined format
+	RequestTime  *float64
+	UpstreamTime *float64
+}
+
+// StatusClass groups statuses as 2xx, 4xx...
+func (e *AccessEntry) StatusClass() string {
+	return fmt.Sprintf("%dxx", e.Status/100)
+}
+
+// accessLine matches nginx's combined log format, optionally followed by more fields
+var accessLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" (\d{3}) (\d+|-) "(?:[^"\\]|\\.)*" "(?:[^"\\]|\\.)*"(.*)$`)
+
+const accessTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// ParseAccess reads a line of the access log
+func ParseAccess(line string) (*AccessEntry, error) {
+	match := accessLine.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("not an access log line: %q", line)
+	}
+	t, err := time.Parse(accessTimeLayout, match[2])
+	if err != nil {
+		return nil, err
+	}
+	entry := &AccessEntry{Time: t.UTC(), Client: match[1], Method: match[3], Path: match[4]}
+	entry.Status, _ = strconv.Atoi(match[5])
+	entry.Bytes, _ = strconv.ParseInt(match[6], 10, 64)
+	if i := strings.IndexByte(entry.Path, '?'); i >= 0 {
+		entry.Path = entry.Path[:i]
+	}
+
+	extra := strings.Fields(match[7])
+	if len(extra) > 0 {
+		entry.RequestTime = parseSeconds(extra[0])
+	}
+	if len(extra) > 1 {
+		entry.UpstreamTime = parseSeconds(extra[1])
+	}
+	return entry, nil
+}
+
+// parseSeconds reads a timing field, which is "-" when there was none, and a list when several
+// upstreams were tried, in which case the last one is taken
+func parseSeconds(value string) *float64 {
+	if i := strings.LastIndexAny(value, ", :"); i >= 0 {
+		value = value[i+1:]
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &seconds
+}
+
+// ErrorEntry is a message of Kong's error log
+type ErrorEntry struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	PID      int       `json:"pid"`
+	Message  string    `json:"message"`
+	Client   string    `json:"client,omitempty"`
+	Server   string    `json:"server,omitempty"`
+	Request  string    `json:"request,omitempty"`
+	Upstream string    `json:"upstream,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	// Route and Service are resolved from the path of the request
+	Route   string `json:"route,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// IsUpstream reports whether the error is about an upstream, i.e. a service behind Kong
+func (e *ErrorEntry) IsUpstream() bool {
+	return e.Upstream != "" || strings.Contains(e.Message, "upstream")
+}
+
+// Path returns the path of the request the error occurred in, if any
+func (e *ErrorEntry) Path() string {
+	fields := strings.Fields(e.Request)
+	if len(fields) < 2 {
+		return ""
+	}
+	path := fields[1]
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
+var errorLine = regexp.MustCompile(`^(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d) \[(\w+)\] (\d+)#\d+: (?:\*\d+ )?(.*)$`)
+
+// errorContext are the fields nginx appends to a message, e.g. `, client: 10.0.0.1`
+var errorContext = regexp.MustCompile(`, (client|server|request|upstream|host): ("(?:[^"\\]|\\.)*"|[^,]*)`)
+
+const errorTimeLayout = "2006/01/02 15:04:05"
+
+// ParseError reads a line of the error log, whose times are in the host's local time
+func ParseError(line string) (*ErrorEntry, error) {
+	match := errorLine.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("not an error log line: %q", line)
+	}
+	t, err := time.ParseInLocation(errorTimeLayout, match[1], time.Local)
+	if err != nil {
+		return nil, err
+	}
+	entry := &ErrorEntry{Time: t.UTC(), Level: match[2]}
+	entry.PID, _ = strconv.Atoi(match[3])
+
+	message := match[4]
+	if loc := errorContext.FindStringIndex(message); loc != nil {
+		for _, field := range errorContext.FindAllStringSubmatch(message[loc[0]:], -1) {
+			value := strings.Trim(field[2], `"`)
+			switch field[1] {
+			case "client":
+				entry.Client = value
+			case "server":
+				entry.Server = value
+			case "request":
+				entry.Request = value
+			case "upstream":
+				entry.Upstream = value
+			case "host":
+				entry.Host = value
+			}
+		}
+		message = message[:loc[0]]
+	}
+	entry.Message = message
+	return entry, nil
+}
@@ -0,0 +1,157 @@
+package kong_logs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAccess(t *testing.T) {
+	entry, err := ParseAccess(`10.0.0.1 - - [01/Jun/2022:10:00:00 +0200] "GET /rest/v1/todos?select=* HTTP/1.1" 200 512 "-" "curl/7.79.1" 0.015 0.012`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Time.Equal(time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC)) || entry.Method != "GET" || entry.Path != "/rest/v1/todos" ||
+		entry.Status != 200 || entry.Bytes != 512 || entry.StatusClass() != "2xx" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.RequestTime == nil || *entry.RequestTime != 0.015 || entry.UpstreamTime == nil || *entry.UpstreamTime != 0.012 {
+		t.Errorf("expected the timings to be parsed, got %v %v", entry.RequestTime, entry.UpstreamTime)
+	}
+
+	combined, err := ParseAccess(`10.0.0.1 - - [01/Jun/2022:10:00:00 +0000] "POST /auth/v1/token HTTP/1.1" 502 - "-" "a \"quoted\" agent"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if combined.Status != 502 || combined.RequestTime != nil || combined.UpstreamTime != nil {
+		t.Errorf("unexpected entry %+v", combined)
+	}
+
+	if _, err := ParseAccess("not a request"); err == nil {
+		t.Error("expected a malformed line to fail")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	entry, err := ParseError(`2022/06/01 10:00:00 [error] 1234#0: *5678 connect() failed (111: Connection refused) while connecting to upstream, client: 10.0.0.1, server: kong, request: "GET /rest/v1/todos HTTP/1.1", upstream: "http://127.0.0.1:3000/todos", host: "project.supabase.co"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ErrorEntry{
+		Time:     time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local).UTC(),
+		Level:    "error",
+		PID:      1234,
+		Message:  "connect() failed (111: Connection refused) while connecting to upstream",
+		Client:   "10.0.0.1",
+		Server:   "kong",
+		Request:  "GET /rest/v1/todos HTTP/1.1",
+		Upstream: "http://127.0.0.1:3000/todos",
+		Host:     "project.supabase.co",
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entry)
+	}
+	if !entry.IsUpstream() || entry.Path() != "/rest/v1/todos" {
+		t.Errorf("expected an upstream error on /rest/v1/todos, got %+v", entry)
+	}
+
+	notice, err := ParseError(`2022/06/01 10:00:01 [notice] 1#0: signal process started`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice.IsUpstream() || notice.Message != "signal process started" {
+		t.Errorf("unexpected entry %+v", notice)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	routes, err := LoadRoutes([]byte(`
+_format_version: "1.1"
+services:
+  - name: rest-v1
+    url: http://localhost:3000/
+    routes:
+      - name: rest-v1-all
+        paths: [/rest/v1/]
+      - name: rest-v1-rpc
+        paths: [/rest/v1/rpc/]
+  - name: auth-v1
+    url: http://localhost:9999/
+    routes:
+      - paths: [/auth/v1/, "~/auth/v2/.*"]
+routes:
+  - name: storage
+    service: storage-v1
+    paths: [/storage/v1/]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string][2]string{
+		"/rest/v1/todos":       {"rest-v1-all", "rest-v1"},
+		"/rest/v1/rpc/hello":   {"rest-v1-rpc", "rest-v1"},
+		"/auth/v1/token":       {"/auth/v1/", "auth-v1"},
+		"/storage/v1/object/a": {"storage", "storage-v1"},
+		"/auth/v2/anything":    {Unmatched, Unmatched},
+		"/favicon.ico":         {Unmatched, Unmatched},
+	} {
+		if route, service := routes.Resolve(path); route != expected[0] || service != expected[1] {
+			t.Errorf("expected %s to resolve to %v, got %s %s", path, expected, route, service)
+		}
+	}
+}
+
+func TestTailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewTailer(path)
+	defer tailer.Close()
+
+	appendTo := func(contents string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poll := func(expected ...string) {
+		t.Helper()
+		lines, err := tailer.Poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) == 0 && len(expected) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("expected %q, got %q", expected, lines)
+		}
+	}
+
+	poll()
+	appendTo("one\ntw")
+	poll("one")
+	appendTo("o\n")
+	poll("two")
+
+	// rotated: the rest of the old file comes first
+	appendTo("three\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendTo("four\n")
+	poll("three", "four")
+
+	// truncated in place
+	if err := os.WriteFile(path, []byte("5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	poll("5")
+}
@@ -0,0 +1,94 @@
+package kong_logs
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unmatched labels requests that match no route of the declarative config
+const Unmatched = "unmatched"
+
+type kongRoute struct {
+	Name    string      `yaml:"name"`
+	Paths   []string    `yaml:"paths"`
+	Service interface{} `yaml:"service"`
+}
+
+type kongService struct {
+	Name   string      `yaml:"name"`
+	Routes []kongRoute `yaml:"routes"`
+}
+
+type declarativeConfig struct {
+	Services []kongService `yaml:"services"`
+	Routes   []kongRoute   `yaml:"routes"`
+}
+
+type routePrefix struct {
+	prefix  string
+	route   string
+	service string
+}
+
+// Routes resolves request paths to the route and service of Kong's declarative config that serve
+// them, by prefix; regex paths aren't supported, and the route's name falls back to its first path
+type Routes struct {
+	prefixes []routePrefix
+}
+
+// LoadRoutes reads the routes of a declarative config
+func LoadRoutes(contents []byte) (*Routes, error) {
+	config := &declarativeConfig{}
+	if err := yaml.Unmarshal(contents, config); err != nil {
+		return nil, err
+	}
+	routes := &Routes{}
+	for _, service := range config.Services {
+		for _, route := range service.Routes {
+			routes.add(route, service.Name)
+		}
+	}
+	for _, route := range config.Routes {
+		// top-level routes refer to their service by name, or by a mapping holding it
+		service := ""
+		switch ref := route.Service.(type) {
+		case string:
+			service = ref
+		case map[string]interface{}:
+			service, _ = ref["name"].(string)
+		}
+		routes.add(route, service)
+	}
+	// the longest prefix wins
+	sort.SliceStable(routes.prefixes, func(i, j int) bool {
+		return len(routes.prefixes[i].prefix) > len(routes.prefixes[j].prefix)
+	})
+	return routes, nil
+}
+
+func (r *Routes) add(route kongRoute, service string) {
+	for _, path := range route.Paths {
+		if strings.HasPrefix(path, "~") {
+			continue
+		}
+		name := route.Name
+		if name == "" {
+			name = route.Paths[0]
+		}
+		r.prefixes = append(r.prefixes, routePrefix{prefix: path, route: name, service: service})
+	}
+}
+
+// Resolve returns the route and service serving a path, Unmatched if none does
+func (r *Routes) Resolve(path string) (route string, service string) {
+	if r != nil {
+		for _, prefix := range r.prefixes {
+			if strings.HasPrefix(path, prefix.prefix) {
+				return prefix.route, prefix.service
+			}
+		}
+	}
+	return Unmatched, Unmatched
+}
@@ -0,0 +1,131 @@
+package kong_logs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+)
+
+// maxLineLength bounds a single line, longer ones are dropped
+const maxLineLength = 64 * 1024
+
+// Tailer reads the lines appended to a file since it was last polled, following it across
+// rotation and truncation
+type Tailer struct {
+	path    string
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial []byte
+}
+
+// NewTailer tails a file from its current end; a file that doesn't exist yet is read from its
+// start once it appears
+func NewTailer(path string) *Tailer {
+	t := &Tailer{path: path}
+	if file, inode, err := t.open(); err == nil {
+		if offset, err := file.Seek(0, io.SeekEnd); err == nil {
+			t.file, t.inode, t.offset = file, inode, offset
+		} else {
+			file.Close()
+		}
+	}
+	return t
+}
+
+func (t *Tailer) open() (*os.File, uint64, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	var inode uint64
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = stat.Ino
+	}
+	return file, inode, nil
+}
+
+// Poll returns the complete lines written since the last poll; when the file was rotated, the
+// rest of the old file is read before moving on to the new one
+func (t *Tailer) Poll() ([]string, error) {
+	lines := make([]string, 0)
+	if t.file != nil {
+		info, err := os.Stat(t.path)
+		rotated := err != nil
+		if err == nil {
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			rotated = ok && stat.Ino != t.inode
+			if !rotated && info.Size() < t.offset {
+				// truncated in place, e.g. by copytruncate
+				t.offset, t.partial = 0, nil
+				if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+					return lines, err
+				}
+			}
+		}
+		if err := t.read(&lines); err != nil {
+			return lines, err
+		}
+		if !rotated {
+			return lines, nil
+		}
+		t.file.Close()
+		t.file, t.partial = nil, nil
+	}
+
+	file, inode, err := t.open()
+	if os.IsNotExist(err) {
+		return lines, nil
+	}
+	if err != nil {
+		return lines, err
+	}
+	t.file, t.inode, t.offset = file, inode, 0
+	return lines, t.read(&lines)
+}
+
+func (t *Tailer) read(lines *[]string) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := t.file.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			data := append(t.partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(data, '\n')
+				if i < 0 {
+					break
+				}
+				if i <= maxLineLength {
+					*lines = append(*lines, string(data[:i]))
+				}
+				data = data[i+1:]
+			}
+			t.partial = append([]byte{}, data...)
+			if len(t.partial) > maxLineLength {
+				// the line will be dropped; keep a byte so that its end isn't taken for a line
+				t.partial = t.partial[:maxLineLength+1]
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close releases the file
+func (t *Tailer) Close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
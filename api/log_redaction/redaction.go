@@ -0,0 +1,197 @@
+package log_redaction
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Placeholder replaces every redacted value, as it does in config files
+const Placeholder = "**redacted**"
+
+const (
+	JWT            = "jwt"
+	URICredentials = "uri_credentials"
+	BearerToken    = "bearer_token"
+)
+
+// builtins are the detectors that run unless disabled; a pattern with a capture group only
+// redacts what the first group matched
+var builtins = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{JWT, regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{URICredentials, regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@'"]+:([^\s@'"]+)@`)},
+	{BearerToken, regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/=-]+)`)},
+}
+
+// Pattern is a user-defined detector
+type Pattern struct {
+	Name string `yaml:"name"`
+	// Regex matches the secret; with a capture group, only what the first group matched is
+	// redacted, e.g. `apikey=(\S+)`
+	Regex string `yaml:"regex"`
+}
+
+// Config is the log_redaction section of adminapi.yaml
+type Config struct {
+	Disabled bool `yaml:"disabled" required:"false"`
+	// DisabledDetectors turns off built-in detectors: jwt, uri_credentials or bearer_token
+	DisabledDetectors []string  `yaml:"disabled_detectors" required:"false"`
+	Patterns          []Pattern `yaml:"patterns" required:"false"`
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Redactor masks secrets in log messages, keeping a count of what each detector redacted since
+// the admin API started, which it exports as a metric
+type Redactor struct {
+	detectors []detector
+
+	mu      sync.Mutex
+	totals  map[string]int
+	counter *prometheus.Desc
+}
+
+// New compiles the detectors of a config; a disabled config redacts nothing
+func New(config Config) (*Redactor, error) {
+	r := &Redactor{
+		totals: make(map[string]int),
+		counter: prometheus.NewDesc(
+			"adminapi_log_redactions_total",
+			"Values masked in log responses, by the detector that found them",
+			[]string{"detector"}, nil,
+		),
+	}
+	if config.Disabled {
+		return r, nil
+	}
+	disabled := make(map[string]bool)
+	for _, name := range config.DisabledDetectors {
+		known := false
+		for _, builtin := range builtins {
+			known = known || builtin.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown log redaction detector %q", name)
+		}
+		disabled[name] = true
+	}
+	for _, builtin := range builtins {
+		if !disabled[builtin.name] {
+			r.detectors = append(r.detectors, detector{builtin.name, builtin.pattern})
+		}
+	}
+	for _, pattern := range config.Patterns {
+		if pattern.Name == "" {
+			return nil, fmt.Errorf("log redaction pattern %q needs a name", pattern.Regex)
+		}
+		for _, d := range r.detectors {
+			if d.name == pattern.Name {
+				return nil, fmt.Errorf("log redaction pattern %q is defined twice", pattern.Name)
+			}
+		}
+		compiled, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log redaction pattern %q: %s", pattern.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{pattern.Name, compiled})
+	}
+	return r, nil
+}
+
+// Redact masks every secret in a message, returning the masked message and the number of values
+// that were masked
+func (r *Redactor) Redact(message string) (string, int) {
+	if r == nil || len(r.detectors) == 0 {
+		return message, 0
+	}
+	count := 0
+	for _, d := range r.detectors {
+		redacted, n := redactMatches(d.pattern, message)
+		if n == 0 {
+			continue
+		}
+		message = redacted
+		count += n
+		r.mu.Lock()
+		r.totals[d.name] += n
+		r.mu.Unlock()
+	}
+	return message, count
+}
+
+// RedactAll masks the secrets of several fields of an entry at once, returning the number of
+// masked values
+func (r *Redactor) RedactAll(fields ...*string) int {
+	count := 0
+	for _, field := range fields {
+		var n int
+		*field, n = r.Redact(*field)
+		count += n
+	}
+	return count
+}
+
+func redactMatches(pattern *regexp.Regexp, message string) (string, int) {
+	matches := pattern.FindAllStringSubmatchIndex(message, -1)
+	if len(matches) == 0 {
+		return message, 0
+	}
+	var b strings.Builder
+	last, count := 0, 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) > 2 {
+			// only the first group is the secret
+			start, end = match[2], match[3]
+		}
+		if start < 0 || message[start:end] == Placeholder {
+			continue
+		}
+		b.WriteString(message[last:start])
+		b.WriteString(Placeholder)
+		last = end
+		count++
+	}
+	b.WriteString(message[last:])
+	return b.String(), count
+}
+
+// DetectorTotal is how many values a detector has redacted
+type DetectorTotal struct {
+	Detector string `json:"detector"`
+	Redacted int    `json:"redacted"`
+}
+
+// Totals returns the number of values each detector redacted since the admin API started
+func (r *Redactor) Totals() []DetectorTotal {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	totals := make([]DetectorTotal, 0, len(r.detectors))
+	for _, d := range r.detectors {
+		totals = append(totals, DetectorTotal{d.name, r.totals[d.name]})
+	}
+	sort.SliceStable(totals, func(i, j int) bool {
+		return totals[i].Redacted > totals[j].Redacted
+	})
+	return totals
+}
+
+func (r *Redactor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.counter
+}
+
+func (r *Redactor) Collect(ch chan<- prometheus.Metric) {
+	for _, total := range r.Totals() {
+		ch <- prometheus.MustNewConstMetric(r.counter, prometheus.CounterValue, float64(total.Redacted), total.Detector)
+	}
+}
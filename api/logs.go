@@ -1,33 +1,113 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/journal"
+	"github.com/supabase/supabase-admin-api/api/log_redaction"
 )
 
-const postgrestService string = "postgrest.service"
+const logQueryTimeout = 30 * time.Second
 
-const pgListenService string = "pglisten.service"
+// logRedactor returns what masks the secrets of a log response, nil when the request asked for
+// them to be revealed
+func (a *API) logRedactor(r *http.Request) *log_redaction.Redactor {
+	if reveal, _ := r.Context().Value(revealSecretsContextKey).(bool); reveal {
+		return nil
+	}
+	return a.logRedaction
+}
 
-const gotrueService string = "gotrue.service"
+// setRedacted reports the number of secrets masked in a response
+func setRedacted(w http.ResponseWriter, count int) {
+	w.Header().Set("X-Redacted", strconv.Itoa(count))
+}
 
-const adminAPIService string = "adminapi.service"
+// parseLogTime reads a since or until parameter, either an RFC 3339 timestamp or a duration into
+// the past, such as 15m
+func parseLogTime(value string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid time %q, expected an RFC 3339 timestamp or a duration", value)
+}
 
-const realtimeService string = "realtime.service"
+func parseLogQuery(r *http.Request) (*journal.Query, error) {
+	params := r.URL.Query()
+	query := &journal.Query{
+		Unit:     getApplication(r).Logs.Unit,
+		Priority: -1,
+		Grep:     params.Get("grep"),
+		Limit:    journal.DefaultLimit,
+		Cursor:   params.Get("cursor"),
+	}
+	var err error
+	if since := params.Get("since"); since != "" {
+		if query.Since, err = parseLogTime(since); err != nil {
+			return nil, err
+		}
+	}
+	if until := params.Get("until"); until != "" {
+		if query.Until, err = parseLogTime(until); err != nil {
+			return nil, err
+		}
+	}
+	if priority := params.Get("priority"); priority != "" {
+		if query.Priority, err = journal.ParsePriority(priority); err != nil {
+			return nil, err
+		}
+	}
+	if query.Grep != "" {
+		if _, err := regexp.Compile(query.Grep); err != nil {
+			return nil, fmt.Errorf("invalid grep pattern: %s", err)
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > journal.MaxLimit {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", limit, journal.MaxLimit)
+		}
+	}
+	return query, nil
+}
 
-const kongService string = "kong.service"
-const kongErrorService string = "kong.service"
+// QueryLogs returns the journal entries of an application as JSON; each entry's cursor continues
+// the query from there
+func (a *API) QueryLogs(w http.ResponseWriter, r *http.Request) error {
+	query, err := parseLogQuery(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
 
-const sysService string = "services.slice"
+	ctx, cancel := context.WithTimeout(r.Context(), logQueryTimeout)
+	defer cancel()
+	entries, err := journal.Read(ctx, *query)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	redactor, redacted := a.logRedactor(r), 0
+	for i := range entries {
+		entries[i].Redacted = redactor.RedactAll(&entries[i].Message)
+		redacted += entries[i].Redacted
+	}
+	setRedacted(w, redacted)
+	return sendJSON(w, http.StatusOK, entries)
+}
 
 // GetLogContents is the method for returning the contents of a given log file
 func (a *API) GetLogContents(w http.ResponseWriter, r *http.Request) error {
-	application := chi.URLParam(r, "application")
-
 	// fetchType is head, tail
 	fetchType := chi.URLParam(r, "type")
 
@@ -38,27 +118,10 @@ func (a *API) GetLogContents(w http.ResponseWriter, r *http.Request) error {
 	reverseArg := "-r"
 	arg0 := "-n"
 	arg1 := "100"
-	serviceName := sysService
-
-	switch application {
-	case "test":
-		serviceName = "./README.md"
-	case "gotrue":
-		serviceName = gotrueService
-	case "postgrest":
-		serviceName = postgrestService
-	case "pglisten":
-		serviceName = pgListenService
-	case "kong":
-		serviceName = kongService
-	case "kong-error":
-		serviceName = kongErrorService
-	case "realtime":
-		serviceName = realtimeService
-	case "admin":
-		serviceName = adminAPIService
-	case "syslog":
-		serviceName = sysService
+	logs := getApplication(r).Logs
+	serviceName := logs.Unit
+	if serviceName == "" {
+		return a.getTextLogContents(w, r, logs.Files, fetchType, n)
 	}
 
 	switch fetchType {
@@ -80,5 +143,7 @@ func (a *API) GetLogContents(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 
-	return sendJSON(w, http.StatusOK, string(stdout))
+	contents, redacted := a.logRedactor(r).Redact(string(stdout))
+	setRedacted(w, redacted)
+	return sendJSON(w, http.StatusOK, contents)
 }
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/journal"
+)
+
+const DefaultMaxLogFollowers = 10
+
+const logHeartbeatInterval = 15 * time.Second
+
+// logFollowers caps how many clients follow logs at once, and ends every stream when the admin API
+// shuts down; streams never go idle, so they would otherwise hold up the shutdown
+type logFollowers struct {
+	slots     chan struct{}
+	heartbeat time.Duration
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newLogFollowers(max int) *logFollowers {
+	return &logFollowers{
+		slots:     make(chan struct{}, max),
+		heartbeat: logHeartbeatInterval,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (f *logFollowers) acquire() bool {
+	select {
+	case f.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *logFollowers) release() {
+	<-f.slots
+}
+
+// Stop ends every stream, now and from then on
+func (f *logFollowers) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+func writeEvent(w http.ResponseWriter, event string, id string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return err
+}
+
+// FollowLogs streams an application's new journal entries as server-sent events, taking the same
+// filters as QueryLogs; a reconnecting client resumes after the entry in its Last-Event-ID
+func (a *API) FollowLogs(w http.ResponseWriter, r *http.Request) error {
+	query, err := parseLogQuery(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if r.URL.Query().Get("limit") == "" {
+		// only entries written from now on
+		query.Limit = 0
+	}
+	if lastEventID := r.Header.Get("Last-Event-ID"); query.Cursor == "" && lastEventID != "" {
+		query.Cursor = lastEventID
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return sendJSON(w, http.StatusInternalServerError, "streaming is not supported")
+	}
+	if !a.logFollowers.acquire() {
+		return sendJSON(w, http.StatusTooManyRequests, "too many clients are following logs")
+	}
+	defer a.logFollowers.release()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	entries := make(chan journal.Entry)
+	done := make(chan error, 1)
+	go func() {
+		done <- journal.Follow(ctx, *query, entries)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	redactor := a.logRedactor(r)
+	heartbeat := time.NewTicker(a.logFollowers.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case entry := <-entries:
+			entry.Redacted = redactor.RedactAll(&entry.Message)
+			if err := writeEvent(w, "entry", entry.Cursor, entry); err != nil {
+				cancel()
+				<-done
+				return nil
+			}
+		case <-heartbeat.C:
+			// besides the request context being cancelled, a failed write is another way of
+			// noticing that the client went away
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				cancel()
+				<-done
+				return nil
+			}
+		case err := <-done:
+			if err != nil {
+				writeEvent(w, "error", "", err.Error())
+				flusher.Flush()
+			}
+			return nil
+		case <-a.logFollowers.stop:
+			writeEvent(w, "shutdown", "", "the admin API is shutting down")
+			flusher.Flush()
+			cancel()
+			<-done
+			return nil
+		case <-ctx.Done():
+			<-done
+			return nil
+		}
+		flusher.Flush()
+	}
+}
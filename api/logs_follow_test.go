@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeJournalctl puts a journalctl on the PATH that prints two entries and then waits to be killed
+func fakeJournalctl(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo '{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1654041600000000","PRIORITY":"6","MESSAGE":"one"}'
+echo '{"__CURSOR":"c2","__REALTIME_TIMESTAMP":"1654041601000000","PRIORITY":"3","MESSAGE":"two"}'
+exec sleep 30
+`
+	if err := os.WriteFile(filepath.Join(dir, "journalctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+}
+
+func followLogs(t *testing.T, ctx context.Context, url string) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("apikey", testAPIKey)
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// readUntil reads lines of an event stream until one has the given prefix
+func readUntil(t *testing.T, reader *bufio.Reader, prefix string) []string {
+	lines := make([]string, 0)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before %q, got %v: %v", prefix, lines, err)
+		}
+		lines = append(lines, strings.TrimRight(line, "\n"))
+		if strings.HasPrefix(line, prefix) {
+			return lines
+		}
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	fakeJournalctl(t)
+	ts, api, _ := lifecycleTestServer(t)
+	defer ts.Close()
+	api.logFollowers = newLogFollowers(1)
+	api.logFollowers.heartbeat = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	first, cancelFirst := context.WithCancel(ctx)
+	response := followLogs(t, first, ts.URL+"/logs/kong/follow?priority=err")
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d", response.StatusCode)
+	}
+	reader := bufio.NewReader(response.Body)
+	lines := readUntil(t, reader, ": heartbeat")
+	stream := strings.Join(lines, "\n")
+	if !strings.Contains(stream, "id: c1\nevent: entry\ndata: {\"cursor\":\"c1\"") || !strings.Contains(stream, `"message":"two"`) {
+		t.Fatalf("expected both entries to be streamed, got %s", stream)
+	}
+
+	if second := followLogs(t, ctx, ts.URL+"/logs/kong/follow"); second.StatusCode != 429 {
+		t.Fatalf("expected followers to be capped, got %d", second.StatusCode)
+	}
+
+	// the follower slot is freed once the client goes away
+	cancelFirst()
+	response.Body.Close()
+	var next *http.Response
+	for i := 0; i < 100; i++ {
+		if next = followLogs(t, ctx, ts.URL+"/logs/kong/follow"); next.StatusCode == 200 {
+			break
+		}
+		next.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if next.StatusCode != 200 {
+		t.Fatalf("expected the follower slot to be released, got %d", next.StatusCode)
+	}
+	defer next.Body.Close()
+
+	api.logFollowers.Stop()
+	readUntil(t, bufio.NewReader(next.Body), "event: shutdown")
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/postgres_logs"
+)
+
+const defaultErrorSummaryWindow = time.Hour
+
+func logFileSource(r *http.Request) postgres_logs.Source {
+	logs := getApplication(r).Logs
+	return postgres_logs.Source{Files: logs.Files, Format: logs.FileFormat, LinePrefix: logs.LinePrefix}
+}
+
+// parseServerLogQuery reads the filters of a server log query; since and until take the same
+// values as journal queries, severity is a PostgreSQL severity such as WARNING
+func parseServerLogQuery(r *http.Request) (*postgres_logs.Query, error) {
+	params := r.URL.Query()
+	query := &postgres_logs.Query{Limit: postgres_logs.DefaultLimit}
+	var err error
+	if since := params.Get("since"); since != "" {
+		if query.Since, err = parseLogTime(since); err != nil {
+			return nil, err
+		}
+	}
+	if until := params.Get("until"); until != "" {
+		if query.Until, err = parseLogTime(until); err != nil {
+			return nil, err
+		}
+	}
+	if severity := params.Get("severity"); severity != "" {
+		if postgres_logs.SeverityRank(severity) == -1 {
+			return nil, fmt.Errorf("unknown severity %q", severity)
+		}
+		query.Severity = severity
+	}
+	if grep := params.Get("grep"); grep != "" {
+		if query.Grep, err = regexp.Compile(grep); err != nil {
+			return nil, fmt.Errorf("invalid grep pattern: %s", err)
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > postgres_logs.MaxLimit {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", limit, postgres_logs.MaxLimit)
+		}
+	}
+	return query, nil
+}
+
+// redactServerLogs masks the secrets of server log entries, returning the number of masked values
+func (a *API) redactServerLogs(r *http.Request, entries []postgres_logs.Entry) int {
+	redactor, redacted := a.logRedactor(r), 0
+	for i := range entries {
+		entry := &entries[i]
+		entry.Redacted = redactor.RedactAll(&entry.Message, &entry.Detail, &entry.Hint, &entry.Context, &entry.Statement)
+		redacted += entry.Redacted
+	}
+	return redacted
+}
+
+// QueryServerLogs returns the entries of the log files an application writes itself, such as
+// PostgreSQL's csvlog, parsed into structured entries
+func (a *API) QueryServerLogs(w http.ResponseWriter, r *http.Request) error {
+	query, err := parseServerLogQuery(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	entries, err := postgres_logs.Read(logFileSource(r), *query)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	setRedacted(w, a.redactServerLogs(r, entries))
+	return sendJSON(w, http.StatusOK, entries)
+}
+
+// ServerLogErrors is the summary of the errors logged over a time window
+type ServerLogErrors struct {
+	Since  time.Time                  `json:"since"`
+	Until  time.Time                  `json:"until"`
+	Total  int                        `json:"total"`
+	Groups []postgres_logs.ErrorGroup `json:"groups"`
+}
+
+// GetServerLogErrors groups the errors of an application's log files by SQLSTATE and message
+// fingerprint, over the last hour unless since is given
+func (a *API) GetServerLogErrors(w http.ResponseWriter, r *http.Request) error {
+	query, err := parseServerLogQuery(r)
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	now := time.Now()
+	if query.Since == nil {
+		since := now.Add(-defaultErrorSummaryWindow)
+		query.Since = &since
+	}
+	if query.Until == nil {
+		query.Until = &now
+	}
+	query.Severity = "ERROR"
+	// every error in the window counts, not just the latest
+	query.Limit = 0
+
+	entries, err := postgres_logs.Read(logFileSource(r), *query)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	// fingerprints are taken from the masked messages, so secrets don't end up in them either
+	setRedacted(w, a.redactServerLogs(r, entries))
+	return sendJSON(w, http.StatusOK, ServerLogErrors{
+		Since:  query.Since.UTC(),
+		Until:  query.Until.UTC(),
+		Total:  len(entries),
+		Groups: postgres_logs.Summarize(entries),
+	})
+}
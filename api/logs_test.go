@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/supabase/supabase-admin-api/monitors"
+)
+
+func TestLogQueryValidation(t *testing.T) {
+	ts, _ := configTestServer(t)
+	defer ts.Close()
+
+	for _, query := range []string{"since=yesterday", "until=-5m", "priority=loud", "grep=(", "limit=0", "limit=5000", "limit=many"} {
+		if response, body := configRequest(t, ts, "GET", "/logs/kong?"+query, "", nil); response.StatusCode != 400 {
+			t.Errorf("expected %s to be rejected, got %d %s", query, response.StatusCode, body)
+		}
+	}
+	if response, _ := configRequest(t, ts, "GET", "/logs/nope", "", nil); response.StatusCode != 404 {
+		t.Errorf("expected an unknown application to be rejected, got %d", response.StatusCode)
+	}
+}
+
+func TestServerLogQueryValidation(t *testing.T) {
+	ts, _ := configTestServer(t)
+	defer ts.Close()
+
+	for _, query := range []string{"severity=LOUD", "since=yesterday", "grep=(", "limit=0"} {
+		if response, body := configRequest(t, ts, "GET", "/logs/postgresql/server?"+query, "", nil); response.StatusCode != 400 {
+			t.Errorf("expected %s to be rejected, got %d %s", query, response.StatusCode, body)
+		}
+	}
+	if response, _ := configRequest(t, ts, "GET", "/logs/kong/errors", "", nil); response.StatusCode != 404 {
+		t.Errorf("expected an application without log files to be rejected, got %d", response.StatusCode)
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo '{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1654041600000000","PRIORITY":"3","MESSAGE":"connection to postgres://admin:hunter2@db/postgres failed"}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "journalctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+	ts, _ := configTestServer(t)
+	defer ts.Close()
+
+	response, body := configRequest(t, ts, "GET", "/logs/kong", "", nil)
+	if response.StatusCode != 200 || strings.Contains(body, "hunter2") || !strings.Contains(body, `"redacted":1`) || response.Header.Get("X-Redacted") != "1" {
+		t.Fatalf("expected the password to be redacted, got %d %q %s", response.StatusCode, response.Header.Get("X-Redacted"), body)
+	}
+	if response, _ := configRequest(t, ts, "GET", "/logs/kong?reveal=true", "", nil); response.StatusCode != 403 {
+		t.Fatalf("expected revealing secrets without the scope to be forbidden, got %d", response.StatusCode)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": SupabaseAdmin, "scope": RevealSecretsScope}).SignedString([]byte("awdawdawdawdawdaw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, body := configRequest(t, ts, "GET", "/logs/kong?reveal=true", "", map[string]string{"apikey": token}); response.StatusCode != 200 || !strings.Contains(body, "hunter2") {
+		t.Fatalf("expected the password to be revealed, got %d %s", response.StatusCode, body)
+	}
+}
+
+func TestTextLogContents(t *testing.T) {
+	dir := t.TempDir()
+	errorLog := filepath.Join(dir, "error.log")
+	lines := "2022/06/01 10:00:00 [notice] 1#0: start worker processes\n" +
+		"2022/06/01 10:00:01 [error] 7#0: *1 connect() failed to postgres://admin:hunter2@db/postgres, client: 10.0.0.1\n" +
+		"2022/06/01 10:00:02 [warn] 7#0: *2 an upstream response is buffered\n"
+	if err := os.WriteFile(errorLog, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIWithVersion(&Config{
+		JwtSecret:                      "awdawdawdawdawdaw",
+		UpstreamMetricsRefreshDuration: "60s",
+		ConfigHistoryDir:               filepath.Join(dir, "history"),
+		JobsDir:                        filepath.Join(dir, "jobs"),
+		MaintenanceDir:                 filepath.Join(dir, "maintenance"),
+		Monitoring:                     monitors.MonitoringConfig{KongLogs: monitors.KongLogMonitorConfig{ErrorLog: errorLog}},
+	}, "0.0")
+	ts := httptest.NewServer(api.handler)
+	defer ts.Close()
+
+	response, body := configRequest(t, ts, "GET", "/logs/kong-error/tail/2", "", nil)
+	expected := `"2022/06/01 10:00:02 [warn] 7#0: *2 an upstream response is buffered\n2022/06/01 10:00:01 [error] 7#0: *1 connect() failed to postgres://admin:**redacted**@db/postgres, client: 10.0.0.1\n"`
+	if response.StatusCode != 200 || strings.TrimSpace(body) != expected || response.Header.Get("X-Redacted") != "1" {
+		t.Errorf("expected the last lines of the error log, newest first and redacted, got %d %s", response.StatusCode, body)
+	}
+	response, body = configRequest(t, ts, "GET", "/logs/kong-error/head/1", "", nil)
+	if response.StatusCode != 200 || strings.TrimSpace(body) != `"2022/06/01 10:00:00 [notice] 1#0: start worker processes\n"` {
+		t.Errorf("expected the first line of the error log, got %d %s", response.StatusCode, body)
+	}
+	if response, _ := configRequest(t, ts, "GET", "/logs/kong-error", "", nil); response.StatusCode != 404 {
+		t.Errorf("expected an application without a journal to be rejected, got %d", response.StatusCode)
+	}
+}
+
+func TestReadTextLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.log")
+	var b strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the last lines span more than one chunk of the file
+	lines, err := readTextLog(path, true, 9000)
+	if err != nil || len(lines) != 9000 || lines[0] != "line 1000" || lines[8999] != "line 9999" {
+		t.Fatalf("expected the last 9000 lines, got %d lines, %v", len(lines), err)
+	}
+	if lines, err := readTextLog(filepath.Join(filepath.Dir(path), "missing.log"), true, 10); err != nil || len(lines) != 0 {
+		t.Errorf("expected a missing file to have no lines, got %v %v", lines, err)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// textLogChunk is how much of a text log file is read at a time when looking for its last lines
+const textLogChunk = 64 * 1024
+
+// newestLogFile returns the most recently modified file matching a glob, or "" if there's none
+func newestLogFile(glob string) (string, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return "", err
+	}
+	newest, newestInfo := "", os.FileInfo(nil)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newest, newestInfo = path, info
+		}
+	}
+	return newest, nil
+}
+
+// readTextLog returns the first n lines of the newest file matching a glob, or its last n lines
+// when fromEnd is set; either way in the order they were written. A missing file has no lines
+func readTextLog(glob string, fromEnd bool, n int) ([]string, error) {
+	path, err := newestLogFile(glob)
+	if err != nil || path == "" {
+		return []string{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if !fromEnd {
+		lines := make([]string, 0, n)
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, textLogChunk), 1024*1024)
+		for len(lines) < n && scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return lines, scanner.Err()
+	}
+
+	// read backwards until the chunks hold n complete lines, or the start of the file
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for offset > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		size := int64(textLogChunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		data = append(chunk, data...)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if offset > 0 {
+		// the first line was cut by the chunk boundary
+		lines = lines[1:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		lines = lines[:0]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// getTextLogContents serves the head or tail of a text log file as GetLogContents does the
+// journal, the tail newest first
+func (a *API) getTextLogContents(w http.ResponseWriter, r *http.Request, glob string, fetchType string, n string) error {
+	count := 100
+	if n != "" {
+		var err error
+		if count, err = strconv.Atoi(n); err != nil || count < 1 {
+			return sendJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid number of lines %q", n))
+		}
+	}
+	fromEnd := fetchType != "head"
+	lines, err := readTextLog(glob, fromEnd, count)
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if fromEnd {
+		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+	}
+
+	contents := ""
+	if len(lines) > 0 {
+		contents = strings.Join(lines, "\n") + "\n"
+	}
+	contents, redacted := a.logRedactor(r).Redact(contents)
+	setRedacted(w, redacted)
+	return sendJSON(w, http.StatusOK, contents)
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/maintenance"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+const DefaultMaintenanceDir = "/var/lib/adminapi/maintenance"
+
+// MaintenanceRequest schedules an operation, either once or in a recurring window
+type MaintenanceRequest struct {
+	Operation maintenance.Operation `json:"operation"`
+	At        *time.Time            `json:"at,omitempty"`
+	Window    *maintenance.Window   `json:"window,omitempty"`
+}
+
+// validateOperation checks an operation could run as it stands, so mistakes are caught when it is
+// scheduled rather than in the middle of the night
+func (a *API) validateOperation(operation *maintenance.Operation) (int, error) {
+	switch operation.Kind {
+	case maintenance.Lifecycle:
+		if operation.Command == "" {
+			operation.Command = Restart
+		}
+		if _, err := parseLifecycleCommand(operation.Command); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if _, _, ok := a.lifecycleTarget(operation.Application); !ok {
+			return http.StatusNotFound, fmt.Errorf("unknown application %q", operation.Application)
+		}
+	case maintenance.ConfigApply:
+		app, ok := a.applications.Get(operation.Application)
+		if !ok || !registry.HasConfig(app) {
+			return http.StatusNotFound, fmt.Errorf("unknown application %q", operation.Application)
+		}
+		current, _, err := readConfigFile(app)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		contents, err := restoreSecrets(app, []byte(operation.RawContents), current)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if validationErr := validateConfigFile(app, contents); validationErr != nil {
+			return http.StatusUnprocessableEntity, validationErr
+		}
+	case maintenance.Backup:
+	default:
+		return http.StatusBadRequest, fmt.Errorf("unknown operation kind %q", operation.Kind)
+	}
+	return 0, nil
+}
+
+// runMaintenance starts the job for a scheduled operation that is due; operations whose restart
+// the restart budget or circuit breaker refuses are skipped
+func (a *API) runMaintenance(entry *maintenance.Entry) (*jobs.Job, error) {
+	job, err := a.startMaintenance(entry)
+	if isRestartRefused(err) {
+		return nil, &maintenance.SkippedError{Reason: err}
+	}
+	return job, err
+}
+
+func (a *API) startMaintenance(entry *maintenance.Entry) (*jobs.Job, error) {
+	operation := entry.Operation
+	switch operation.Kind {
+	case maintenance.Lifecycle:
+		lifecycleCommand, err := parseLifecycleCommand(operation.Command)
+		if err != nil {
+			return nil, err
+		}
+		unit, async, ok := a.lifecycleTarget(operation.Application)
+		if !ok {
+			return nil, fmt.Errorf("unknown application %q", operation.Application)
+		}
+		if unit == registry.SysService && lifecycleCommand == Restart {
+			return a.startStackRestartJob(entry.Author)
+		}
+		return a.startLifecycleJob(lifecycleCommand, unit, async, entry.Author)
+	case maintenance.ConfigApply:
+		app, ok := a.applications.Get(operation.Application)
+		if !ok || !registry.HasConfig(app) {
+			return nil, fmt.Errorf("unknown application %q", operation.Application)
+		}
+		if operation.RestartServices {
+			if err := a.checkConfigRestart(app); err != nil {
+				return nil, err
+			}
+		}
+		return a.jobs.Submit(jobs.Spec{
+			Kind:        "config-apply",
+			Description: fmt.Sprintf("scheduled %s config change", app.Name),
+			Author:      entry.Author,
+			Run: func(ctx context.Context, output io.Writer) error {
+				return a.applyScheduledConfig(ctx, output, app, operation, entry.Author)
+			},
+		})
+	case maintenance.Backup:
+		return a.jobs.Submit(backupJobSpec(&BackupConfiguration{ProjectId: operation.ProjectId, BackupId: operation.BackupId}, entry.Author))
+	}
+	return nil, fmt.Errorf("unknown operation kind %q", operation.Kind)
+}
+
+// applyScheduledConfig writes a scheduled config file, revalidating it against the file as it is
+// now, and restarts its service as a config write through the API would. Once ctx is done, nothing
+// more is written, and a guarded apply still waiting on its service rolls back
+func (a *API) applyScheduledConfig(ctx context.Context, output io.Writer, app *registry.Application, operation maintenance.Operation, author string) error {
+	unlock := a.lockConfig(app.Name)
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	current, _, err := readConfigFile(app)
+	if err != nil {
+		return err
+	}
+	contents, err := restoreSecrets(app, []byte(operation.RawContents), current)
+	if err != nil {
+		return err
+	}
+	if validationErr := validateConfigFile(app, contents); validationErr != nil {
+		return validationErr
+	}
+
+	if operation.RestartServices && canGuardApply(app) {
+		report, err := a.guardedApply(ctx, app, contents, author)
+		if err != nil {
+			return err
+		}
+		for _, step := range report.Steps {
+			fmt.Fprintln(output, step)
+		}
+		if report.Outcome != Applied {
+			return fmt.Errorf("%s didn't come back after the config change, outcome %s", app.Name, report.Outcome)
+		}
+		return nil
+	}
+
+	if operation.RestartServices {
+		if err := a.checkConfigRestart(app); err != nil {
+			return err
+		}
+	}
+	bytesWritten, err := a.writeConfigFile(app, contents, author)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "wrote %d bytes to %s\n", bytesWritten, app.Config.Path)
+	if operation.RestartServices && app.Restartable() {
+		job, err := a.startLifecycleJob(Restart, app.Unit, app.Async, author)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "restarting %s in job %s\n", app.Unit, job.ID)
+	}
+	return nil
+}
+
+// redactMaintenance hides the secrets of scheduled config changes, as config reads do
+func (a *API) redactMaintenance(r *http.Request, entry *maintenance.Entry) (*maintenance.Entry, error) {
+	if entry.Operation.Kind != maintenance.ConfigApply {
+		return entry, nil
+	}
+	app, ok := a.applications.Get(entry.Operation.Application)
+	if !ok {
+		return entry, nil
+	}
+	redacted, _, err := redactConfig(r, app, []byte(entry.Operation.RawContents))
+	if err != nil {
+		return nil, err
+	}
+	entry.Operation.RawContents = string(redacted)
+	return entry, nil
+}
+
+// ListMaintenance returns every scheduled operation, the next due first
+func (a *API) ListMaintenance(w http.ResponseWriter, r *http.Request) error {
+	entries := a.maintenance.List()
+	for i, entry := range entries {
+		redacted, err := a.redactMaintenance(r, entry)
+		if err != nil {
+			return sendJSON(w, http.StatusInternalServerError, err.Error())
+		}
+		entries[i] = redacted
+	}
+	return sendJSON(w, http.StatusOK, entries)
+}
+
+// ScheduleMaintenance schedules a lifecycle command, config change or backup
+func (a *API) ScheduleMaintenance(w http.ResponseWriter, r *http.Request) error {
+	params := &MaintenanceRequest{}
+	jsonDecoder := json.NewDecoder(r.Body)
+	if err := jsonDecoder.Decode(params); err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if status, err := a.validateOperation(&params.Operation); err != nil {
+		if validationErr, ok := err.(*ConfigValidationError); ok {
+			return sendJSON(w, status, validationErr)
+		}
+		return sendJSON(w, status, err.Error())
+	}
+
+	entry, err := a.maintenance.Add(params.Operation, params.At, params.Window, getSubject(r))
+	if err != nil {
+		return sendJSON(w, http.StatusBadRequest, err.Error())
+	}
+	if entry, err = a.redactMaintenance(r, entry); err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusCreated, entry)
+}
+
+// GetMaintenance returns a single scheduled operation, along with the jobs it has started
+func (a *API) GetMaintenance(w http.ResponseWriter, r *http.Request) error {
+	entry, err := a.maintenance.Get(chi.URLParam(r, "id"))
+	if err == maintenance.ErrEntryNotFound {
+		return sendJSON(w, http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if entry, err = a.redactMaintenance(r, entry); err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, entry)
+}
+
+// CancelMaintenance removes an operation from the schedule
+func (a *API) CancelMaintenance(w http.ResponseWriter, r *http.Request) error {
+	entry, err := a.maintenance.Cancel(chi.URLParam(r, "id"))
+	if err == maintenance.ErrEntryNotFound {
+		return sendJSON(w, http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	if entry, err = a.redactMaintenance(r, entry); err != nil {
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+	return sendJSON(w, http.StatusOK, entry)
+}
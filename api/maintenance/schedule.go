@@ -0,0 +1,415 @@
+package maintenance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+)
+
+type State = string
+
+const (
+	Scheduled State = "scheduled"
+	// Done one-off entries have run; recurring entries stay scheduled
+	Done State = "done"
+	// Missed one-off entries were due while the admin API was down for longer than MissedGrace
+	Missed State = "missed"
+	// Skipped one-off entries were due while their operation wasn't allowed to run, e.g. a restart
+	// of a unit whose circuit breaker is open
+	Skipped State = "skipped"
+)
+
+// SkippedError is returned by a Runner refusing to run an operation that is due, which is then
+// recorded as skipped rather than failed
+type SkippedError struct {
+	Reason error
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("skipped: %s", e.Reason)
+}
+
+type OperationKind = string
+
+const (
+	Lifecycle   OperationKind = "lifecycle"
+	ConfigApply OperationKind = "config-apply"
+	Backup      OperationKind = "backup"
+)
+
+// MissedGrace is how late an entry may still run, e.g. after the admin API was restarted
+const MissedGrace = time.Hour
+
+// runHistoryLimit is how many runs are kept per entry
+const runHistoryLimit = 10
+
+var ErrEntryNotFound = errors.New("maintenance entry not found")
+
+// Operation describes what runs when an entry is due
+type Operation struct {
+	Kind        OperationKind `json:"kind"`
+	Application string        `json:"application,omitempty"`
+	// Command is the lifecycle command of lifecycle operations, restart by default
+	Command string `json:"command,omitempty"`
+	// RawContents and RestartServices are the config file written by config-apply operations
+	RawContents     string `json:"raw_contents,omitempty"`
+	RestartServices bool   `json:"restart_services,omitempty"`
+	// ProjectId and BackupId identify the backups taken by backup operations
+	ProjectId int `json:"project_id,omitempty"`
+	BackupId  int `json:"backup_id,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring maintenance window, starting at the same time on the given days
+type Window struct {
+	// Days are three-letter weekday names (sun, mon...); every day if empty
+	Days []string `json:"days,omitempty"`
+	// Time is when the window starts, as HH:MM
+	Time     string `json:"time"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Next returns the first start of the window after t
+func (w *Window) Next(t time.Time) (time.Time, error) {
+	location := time.UTC
+	if w.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	parts := strings.Split(w.Time, ":")
+	if len(parts) != 2 {
+		return time.Time{}, fmt.Errorf("invalid window time %q, expected HH:MM", w.Time)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return time.Time{}, fmt.Errorf("invalid window time %q, expected HH:MM", w.Time)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return time.Time{}, fmt.Errorf("invalid window time %q, expected HH:MM", w.Time)
+	}
+	days := make(map[time.Weekday]bool)
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return time.Time{}, fmt.Errorf("invalid window day %q", day)
+		}
+		days[weekday] = true
+	}
+
+	local := t.In(location)
+	// a week and a day covers every weekday, whatever time of day t is
+	for i := 0; i <= 7; i++ {
+		day := local.AddDate(0, 0, i)
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, location)
+		if start.After(t) && (len(days) == 0 || days[start.Weekday()]) {
+			return start.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("window %+v never starts", w)
+}
+
+// Run records an entry running
+type Run struct {
+	At      time.Time `json:"at"`
+	JobID   string    `json:"job_id,omitempty"`
+	Missed  bool      `json:"missed,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Entry is an operation scheduled either once, at a given time, or in a recurring window
+type Entry struct {
+	ID        string     `json:"id"`
+	Operation Operation  `json:"operation"`
+	At        *time.Time `json:"at,omitempty"`
+	Window    *Window    `json:"window,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	State     State      `json:"state"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	Runs      []Run      `json:"runs"`
+}
+
+// Runner starts the job for an operation that is due
+type Runner func(entry *Entry) (*jobs.Job, error)
+
+// Scheduler runs operations when they are due, keeping the schedule on disk as <dir>/<id>.json so
+// it survives admin API restarts
+type Scheduler struct {
+	dir      string
+	run      Runner
+	interval time.Duration
+	now      func() time.Time
+	doneChan chan bool
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// NewScheduler loads the schedule persisted in dir
+func NewScheduler(dir string, run Runner) (*Scheduler, error) {
+	s := &Scheduler{
+		dir:      dir,
+		run:      run,
+		interval: 30 * time.Second,
+		now:      time.Now,
+		doneChan: make(chan bool, 1),
+		entries:  make(map[string]*Entry),
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "couldn't read maintenance entry %s", path)
+		}
+		entry := &Entry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			logrus.WithError(err).WithField("path", path).Warn("skipping unreadable maintenance entry")
+			continue
+		}
+		s.entries[entry.ID] = entry
+	}
+	return s, nil
+}
+
+// Add schedules an operation, either once at a time in the future or in a recurring window
+func (s *Scheduler) Add(operation Operation, at *time.Time, window *Window, author string) (*Entry, error) {
+	if (at == nil) == (window == nil) {
+		return nil, errors.New("exactly one of at and window is required")
+	}
+	now := s.now()
+	entry := &Entry{
+		Operation: operation,
+		Window:    window,
+		Author:    author,
+		CreatedAt: now.UTC(),
+		State:     Scheduled,
+		Runs:      make([]Run, 0),
+	}
+	if at != nil {
+		if !at.After(now) {
+			return nil, fmt.Errorf("%s is in the past", at.Format(time.RFC3339))
+		}
+		utc := at.UTC()
+		entry.At, entry.NextRun = &utc, &utc
+	} else {
+		next, err := window.Next(now)
+		if err != nil {
+			return nil, err
+		}
+		entry.NextRun = &next
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = id
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(entry); err != nil {
+		return nil, err
+	}
+	s.entries[id] = entry
+	return snapshot(entry), nil
+}
+
+// Get returns a single entry
+func (s *Scheduler) Get(id string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return snapshot(entry), nil
+}
+
+// List returns every entry, the next due first
+func (s *Scheduler) List() []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, snapshot(entry))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].NextRun == nil) != (entries[j].NextRun == nil) {
+			return entries[i].NextRun != nil
+		}
+		if entries[i].NextRun != nil && !entries[i].NextRun.Equal(*entries[j].NextRun) {
+			return entries[i].NextRun.Before(*entries[j].NextRun)
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// Cancel removes an entry from the schedule; jobs it already started are left alone
+func (s *Scheduler) Cancel(id string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	delete(s.entries, id)
+	return snapshot(entry), nil
+}
+
+// RunDue starts every operation that is due; entries more than MissedGrace overdue are skipped
+func (s *Scheduler) RunDue() {
+	now := s.now()
+	due := make([]*Entry, 0)
+	s.mu.Lock()
+	for _, entry := range s.entries {
+		if entry.State == Scheduled && entry.NextRun != nil && !entry.NextRun.After(now) {
+			due = append(due, snapshot(entry))
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextRun.Before(*due[j].NextRun)
+	})
+
+	for _, entry := range due {
+		log := logrus.WithField("maintenance", entry.ID).WithField("kind", entry.Operation.Kind)
+		run := Run{At: now.UTC()}
+		if now.Sub(*entry.NextRun) > MissedGrace {
+			run.Missed = true
+			run.Error = fmt.Sprintf("missed, was due at %s", entry.NextRun.Format(time.RFC3339))
+			log.Warn(run.Error)
+		} else if job, err := s.run(entry); err != nil {
+			run.Error = err.Error()
+			if _, skipped := err.(*SkippedError); skipped {
+				run.Skipped = true
+				log.WithError(err).Warn("skipped scheduled maintenance")
+			} else {
+				log.WithError(err).Error("failed to start scheduled maintenance")
+			}
+		} else {
+			run.JobID = job.ID
+			log.WithField("job", job.ID).Info("started scheduled maintenance")
+		}
+		s.finishRun(entry.ID, run, now)
+	}
+}
+
+func (s *Scheduler) finishRun(id string, run Run, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		// cancelled while it was starting
+		return
+	}
+	entry.Runs = append(entry.Runs, run)
+	if len(entry.Runs) > runHistoryLimit {
+		entry.Runs = entry.Runs[len(entry.Runs)-runHistoryLimit:]
+	}
+	if entry.Window != nil {
+		next, err := entry.Window.Next(now)
+		if err == nil {
+			entry.NextRun = &next
+		}
+	} else {
+		entry.NextRun = nil
+		if run.Missed {
+			entry.State = Missed
+		} else if run.Skipped {
+			entry.State = Skipped
+		} else {
+			entry.State = Done
+		}
+	}
+	if err := s.save(entry); err != nil {
+		logrus.WithError(err).WithField("maintenance", id).Warn("failed to persist maintenance entry")
+	}
+}
+
+// Start runs due operations until Stop is called
+func (s *Scheduler) Start() {
+	logrus.WithField("component", "maintenance").Infof("Starting maintenance scheduler with %d entries.", len(s.List()))
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	s.RunDue()
+	for {
+		select {
+		case <-s.doneChan:
+			logrus.WithField("component", "maintenance").Info("Received stop signal. Stopping maintenance scheduler.")
+			return
+		case <-t.C:
+			s.RunDue()
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	s.doneChan <- true
+}
+
+func (s *Scheduler) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Scheduler) save(entry *Entry) error {
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path(entry.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path(entry.ID))
+}
+
+func snapshot(entry *Entry) *Entry {
+	copied := *entry
+	copied.Runs = append([]Run{}, entry.Runs...)
+	return &copied
+}
+
+func newID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
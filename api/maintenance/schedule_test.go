@@ -0,0 +1,105 @@
+package maintenance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/jobs"
+)
+
+func TestWindowNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		window   Window
+		expected time.Time
+	}{
+		{Window{Time: "13:30"}, time.Date(2022, 6, 1, 13, 30, 0, 0, time.UTC)},
+		{Window{Time: "02:00"}, time.Date(2022, 6, 2, 2, 0, 0, 0, time.UTC)},
+		{Window{Time: "12:00", Days: []string{"wed"}}, time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)},
+		{Window{Time: "03:00", Days: []string{"sun", "mon"}}, time.Date(2022, 6, 5, 3, 0, 0, 0, time.UTC)},
+		{Window{Time: "02:00", Timezone: "America/New_York"}, time.Date(2022, 6, 2, 6, 0, 0, 0, time.UTC)},
+	} {
+		next, err := tc.window.Next(now)
+		if err != nil || !next.Equal(tc.expected) {
+			t.Errorf("expected %+v to next start at %s, got %s %v", tc.window, tc.expected, next, err)
+		}
+	}
+	for _, window := range []Window{{Time: "25:00"}, {Time: "2pm"}, {Time: "02:00", Days: []string{"someday"}}} {
+		if _, err := window.Next(now); err == nil {
+			t.Errorf("expected %+v to be rejected", window)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	started := make([]string, 0)
+	runner := func(entry *Entry) (*jobs.Job, error) {
+		if entry.Operation.Application == "pgbouncer" {
+			return nil, &SkippedError{Reason: errors.New("circuit breaker open")}
+		}
+		started = append(started, entry.Operation.Application)
+		return &jobs.Job{ID: "job-" + entry.Operation.Application}, nil
+	}
+	s, err := NewScheduler(dir, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	at := now.Add(time.Hour)
+	once, err := s.Add(Operation{Kind: Lifecycle, Application: "postgresql"}, &at, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recurring, err := s.Add(Operation{Kind: Lifecycle, Application: "kong"}, nil, &Window{Time: "14:00"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, err := s.Add(Operation{Kind: Lifecycle, Application: "pgbouncer"}, &at, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(Operation{Kind: Lifecycle}, &now, nil, ""); err == nil {
+		t.Fatal("expected an entry in the past to be rejected")
+	}
+
+	s.RunDue()
+	if len(started) != 0 {
+		t.Fatalf("expected nothing to be due yet, got %v", started)
+	}
+
+	now = now.Add(2 * time.Hour)
+	s.RunDue()
+	if len(started) != 2 || started[0] != "postgresql" || started[1] != "kong" {
+		t.Fatalf("expected both entries to run, earliest first, got %v", started)
+	}
+	if entry, _ := s.Get(once.ID); entry.State != Done || entry.NextRun != nil || entry.Runs[0].JobID != "job-postgresql" {
+		t.Fatalf("expected the one-off entry to be done, got %+v", entry)
+	}
+	if entry, _ := s.Get(skipped.ID); entry.State != Skipped || !entry.Runs[0].Skipped || entry.Runs[0].Error != "skipped: circuit breaker open" {
+		t.Fatalf("expected the refused entry to be skipped, got %+v", entry)
+	}
+	if entry, _ := s.Get(recurring.ID); entry.State != Scheduled || !entry.NextRun.Equal(time.Date(2022, 6, 2, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the recurring entry to be scheduled for the next day, got %+v", entry)
+	}
+
+	// the schedule survives restarts, and windows missed while the admin API was down are skipped
+	s, _ = NewScheduler(dir, runner)
+	now = now.Add(48 * time.Hour)
+	s.now = func() time.Time { return now }
+	s.RunDue()
+	if entry, _ := s.Get(recurring.ID); len(started) != 2 || len(entry.Runs) != 2 || !entry.Runs[1].Missed {
+		t.Fatalf("expected the missed window to be skipped, got %v %+v", started, entry)
+	}
+
+	if _, err := s.Cancel(recurring.ID); err != nil {
+		t.Fatal(err)
+	}
+	if entries := s.List(); len(entries) != 2 || entries[0].ID == recurring.ID || entries[1].ID == recurring.ID {
+		t.Fatalf("expected the cancelled entry to be removed, got %+v", entries)
+	}
+}
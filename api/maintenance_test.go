@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/maintenance"
+)
+
+func TestMaintenanceSchedule(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "kong.service")
+	defer ts.Close()
+
+	at := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for body, status := range map[string]int{
+		fmt.Sprintf(`{"operation": {"kind": "lifecycle", "application": "kong", "command": "explode"}, "at": %q}`, at): 400,
+		fmt.Sprintf(`{"operation": {"kind": "lifecycle", "application": "nope"}, "at": %q}`, at):                       404,
+		fmt.Sprintf(`{"operation": {"kind": "vacuum"}, "at": %q}`, at):                                                 400,
+		`{"operation": {"kind": "lifecycle", "application": "kong"}}`:                                                  400,
+		`{"operation": {"kind": "lifecycle", "application": "kong"}, "at": "2020-01-01T00:00:00Z"}`:                    400,
+		`{"operation": {"kind": "lifecycle", "application": "kong"}, "window": {"time": "26:00"}}`:                     400,
+	} {
+		if response, responseBody := configRequest(t, ts, "POST", "/maintenance/schedule", body, nil); response.StatusCode != status {
+			t.Errorf("expected %s to get a %d, got %d %s", body, status, response.StatusCode, responseBody)
+		}
+	}
+
+	response, body := configRequest(t, ts, "POST", "/maintenance/schedule", fmt.Sprintf(`{"operation": {"kind": "lifecycle", "application": "kong"}, "at": %q}`, at), nil)
+	once := &maintenance.Entry{}
+	if err := json.Unmarshal([]byte(body), once); err != nil || response.StatusCode != 201 || once.Operation.Command != Restart || once.NextRun.Format(time.RFC3339) != at {
+		t.Fatalf("expected a restart to be scheduled, got %d %s", response.StatusCode, body)
+	}
+	response, body = configRequest(t, ts, "POST", "/maintenance/schedule", `{"operation": {"kind": "backup", "project_id": 1, "backup_id": 2}, "window": {"days": ["sun"], "time": "02:00"}}`, nil)
+	if response.StatusCode != 201 {
+		t.Fatalf("expected a weekly backup to be scheduled, got %d %s", response.StatusCode, body)
+	}
+
+	response, body = configRequest(t, ts, "GET", "/maintenance/schedule", "", nil)
+	entries := make([]*maintenance.Entry, 0)
+	if err := json.Unmarshal([]byte(body), &entries); err != nil || len(entries) != 2 || entries[0].ID != once.ID {
+		t.Fatalf("expected both entries, the restart first, got %d %s", response.StatusCode, body)
+	}
+
+	job, err := api.runMaintenance(once)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = waitForJob(t, api, job.ID); job.State != jobs.Succeeded || fake.CallLog()[1] != "restart kong.service" {
+		t.Fatalf("expected the scheduled restart to run as a job, got %+v %v", job, fake.CallLog())
+	}
+
+	// a unit in crash-loop protection isn't restarted by a maintenance window
+	for i := 0; i < 3; i++ {
+		api.restartLimits.Record("kong.service", false)
+	}
+	if _, err := api.runMaintenance(once); err == nil {
+		t.Fatal("expected the restart of a unit with an open circuit breaker to be refused")
+	} else if _, skipped := err.(*maintenance.SkippedError); !skipped || len(restartedUnits(fake)) != 1 {
+		t.Fatalf("expected the scheduled restart to be skipped, got %v %v", err, fake.CallLog())
+	}
+
+	if response, _ := configRequest(t, ts, "DELETE", "/maintenance/schedule/"+once.ID, "", nil); response.StatusCode != 200 {
+		t.Fatalf("expected the entry to be cancelled, got %d", response.StatusCode)
+	}
+	if response, _ := configRequest(t, ts, "GET", "/maintenance/schedule/"+once.ID, "", nil); response.StatusCode != 404 {
+		t.Fatalf("expected the cancelled entry to be gone, got %d", response.StatusCode)
+	}
+}
+
+func TestScheduledConfigApplyGivesUp(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "gotrue.service")
+	defer ts.Close()
+	app, _ := api.applications.Get("gotrue")
+	app.Config.Path = filepath.Join(t.TempDir(), "gotrue.env")
+	if err := os.WriteFile(app.Config.Path, []byte("GOTRUE_JWT_EXP=3600\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	operation := maintenance.Operation{Kind: maintenance.ConfigApply, Application: "gotrue", RawContents: "GOTRUE_JWT_EXP=60\n", RestartServices: true}
+	if err := api.applyScheduledConfig(ctx, io.Discard, app, operation, "test"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled apply to give up, got %v", err)
+	}
+	if contents, _ := os.ReadFile(app.Config.Path); string(contents) != "GOTRUE_JWT_EXP=3600\n" || len(restartedUnits(fake)) != 0 {
+		t.Fatalf("expected nothing to be written or restarted, got %q %v", contents, fake.CallLog())
+	}
+}
@@ -0,0 +1,100 @@
+package metrics_endpoint
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	prom "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// TypeConflictError is returned when a family is merged into one of the same name but another type
+type TypeConflictError struct {
+	Family   string
+	Type     prom.MetricType
+	Existing prom.MetricType
+}
+
+func (e *TypeConflictError) Error() string {
+	return fmt.Sprintf("metric family %s is a %s, but was already merged as a %s",
+		e.Family, strings.ToLower(e.Type.String()), strings.ToLower(e.Existing.String()))
+}
+
+// familySet merges metric families by name, so that each is exposed once
+type familySet struct {
+	families map[string]*prom.MetricFamily
+	// series holds the label signatures of each family's samples
+	series map[string]map[string]bool
+}
+
+func newFamilySet() *familySet {
+	return &familySet{families: make(map[string]*prom.MetricFamily), series: make(map[string]map[string]bool)}
+}
+
+// labelSignature identifies a sample's labels regardless of their order
+func labelSignature(metric *prom.Metric) string {
+	pairs := make([]string, 0, len(metric.Label))
+	for _, pair := range metric.Label {
+		pairs = append(pairs, pair.GetName()+"\xff"+pair.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// add merges a family's samples into the family of the same name, keeping the HELP of the first.
+// A family of another type is rejected as a whole with a TypeConflictError; samples whose labels
+// are already in the family are dropped, and counted
+func (f *familySet) add(family *prom.MetricFamily) (duplicates int, err error) {
+	name := family.GetName()
+	merged, ok := f.families[name]
+	if !ok {
+		merged = &prom.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+		f.families[name] = merged
+		f.series[name] = make(map[string]bool)
+	} else if merged.GetType() != family.GetType() {
+		return 0, &TypeConflictError{Family: name, Type: family.GetType(), Existing: merged.GetType()}
+	}
+	for _, metric := range family.Metric {
+		signature := labelSignature(metric)
+		if f.series[name][signature] {
+			duplicates++
+			continue
+		}
+		f.series[name][signature] = true
+		merged.Metric = append(merged.Metric, metric)
+	}
+	return duplicates, nil
+}
+
+// replace sets a family, dropping whatever was merged under its name
+func (f *familySet) replace(family *prom.MetricFamily) {
+	delete(f.families, family.GetName())
+	_, _ = f.add(family)
+}
+
+// write exposes the families sorted by name, and their samples by labels
+func (f *familySet) write(out io.Writer) error {
+	names := make([]string, 0, len(f.families))
+	for name, family := range f.families {
+		if len(family.Metric) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := f.families[name]
+		signatures := make(map[*prom.Metric]string, len(family.Metric))
+		for _, metric := range family.Metric {
+			signatures[metric] = labelSignature(metric)
+		}
+		sort.SliceStable(family.Metric, func(i, j int) bool {
+			return signatures[family.Metric[i]] < signatures[family.Metric[j]]
+		})
+		if _, err := expfmt.MetricFamilyToText(out, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,13 @@ package metrics_endpoint
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
+	"time"
 
 	prom "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
@@ -16,58 +21,216 @@ type MetricsSourceConfig struct {
 	LabelsToAttach []*prom.LabelPair `yaml:"labels_to_attach"`
 	SkipTlsVerify  bool              `yaml:"skip_tls_verify" required:"false"`
 	SourceTimeout  string            `yaml:"source_timeout" required:"false"`
+	// MetricRelabelConfigs are applied in order to every sample, after LabelsToAttach
+	MetricRelabelConfigs []RelabelConfig `yaml:"metric_relabel_configs" required:"false"`
 }
 
 type MetricsSource struct {
 	Config     MetricsSourceConfig
 	HttpClient *http.Client
 	Logger     logrus.FieldLogger
-	Parser     *expfmt.TextParser
+	Relabeler  *Relabeler
 }
 
 type Metrics struct {
 	Sources []MetricsSource
+	// Timeout bounds a whole merge; sources that haven't answered by then are reported as down
+	Timeout time.Duration
 }
 
+// scrape is the outcome of fetching a source
+type scrape struct {
+	families map[string]*prom.MetricFamily
+	samples  int
+	duration time.Duration
+	err      error
+	// conflicts counts the source's families dropped for having another type than a family of
+	// the same name from another source
+	conflicts int
+}
+
+// GetMergedMetrics scrapes every source concurrently and merges their families, each exposed once;
+// the adminapi_upstream_* series describe each scrape, so that a failed source can be told apart
+// from missing series
 func (m *Metrics) GetMergedMetrics() string {
+	ctx := context.Background()
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		index int
+		scrape
+	}
+	// buffered, so that sources answering after the deadline don't block
+	results := make(chan result, len(m.Sources))
+	start := time.Now()
+	for i := range m.Sources {
+		go func(i int) {
+			source := &m.Sources[i]
+			begin := time.Now()
+			families, samples, err := source.GetAndLabelMetrics(ctx)
+			results <- result{index: i, scrape: scrape{families: families, samples: samples, duration: time.Since(begin), err: err}}
+		}(i)
+	}
+
+	scrapes := make([]*scrape, len(m.Sources))
+	for received := 0; received < len(m.Sources); received++ {
+		select {
+		case r := <-results:
+			scrapes[r.index] = &r.scrape
+		case <-ctx.Done():
+			received = len(m.Sources)
+		}
+	}
+
+	// sources are merged in order, so a family's type and HELP come from the first source exporting it
+	merged := newFamilySet()
+	for i, source := range m.Sources {
+		if scrapes[i] == nil {
+			source.Logger.Info("Upstream source didn't answer before the deadline")
+			scrapes[i] = &scrape{duration: time.Since(start), err: ctx.Err()}
+		}
+		for _, name := range sortedFamilyNames(scrapes[i].families) {
+			duplicates, err := merged.add(scrapes[i].families[name])
+			if err != nil {
+				scrapes[i].conflicts++
+				source.Logger.WithError(err).Warn("Dropped upstream metric family")
+			} else if duplicates > 0 {
+				source.Logger.WithField("family", name).WithField("duplicates", duplicates).Warn("Dropped upstream series already exported by another source")
+			}
+		}
+	}
+	// the series describing scrapes take precedence over whatever upstream sources export
+	for _, family := range m.scrapeFamilies(scrapes) {
+		merged.replace(family)
+	}
+
 	var buffer bytes.Buffer
-	for _, source := range m.Sources {
-		buffer.Write(source.GetAndLabelMetrics())
+	if err := merged.write(&buffer); err != nil {
+		logrus.WithError(err).Warn("Failed to write out merged metrics")
 	}
 	return buffer.String()
 }
 
-func (s *MetricsSource) GetAndLabelMetrics() []byte {
-	req, err := http.NewRequest("GET", s.Config.Url, nil)
+func sortedFamilyNames(families map[string]*prom.MetricFamily) []string {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Metrics) scrapeFamilies(scrapes []*scrape) []*prom.MetricFamily {
+	up := gaugeFamily("adminapi_upstream_up", "Whether the last scrape of the upstream source succeeded")
+	duration := gaugeFamily("adminapi_upstream_scrape_duration_seconds", "How long the last scrape of the upstream source took")
+	samples := gaugeFamily("adminapi_upstream_samples", "Samples exported by the upstream source in its last scrape, after relabeling")
+	conflicts := gaugeFamily("adminapi_upstream_type_conflicts", "Families of the upstream source dropped in its last scrape for having another type than the same family of a previous source")
+	for i, source := range m.Sources {
+		value := 0.0
+		if scrapes[i].err == nil {
+			value = 1
+		}
+		addGauge(up, source.Config.Name, value)
+		addGauge(duration, source.Config.Name, scrapes[i].duration.Seconds())
+		addGauge(samples, source.Config.Name, float64(scrapes[i].samples))
+		addGauge(conflicts, source.Config.Name, float64(scrapes[i].conflicts))
+	}
+	return []*prom.MetricFamily{up, duration, samples, conflicts}
+}
+
+func gaugeFamily(name string, help string) *prom.MetricFamily {
+	return &prom.MetricFamily{Name: stringPointer(name), Help: stringPointer(help), Type: prom.MetricType_GAUGE.Enum()}
+}
+
+func addGauge(family *prom.MetricFamily, source string, value float64) {
+	family.Metric = append(family.Metric, &prom.Metric{
+		Label: []*prom.LabelPair{{Name: stringPointer("source"), Value: stringPointer(source)}},
+		Gauge: &prom.Gauge{Value: &value},
+	})
+}
+
+// GetAndLabelMetrics fetches the source's metric families, returning them relabeled along with their
+// number of samples
+func (s *MetricsSource) GetAndLabelMetrics(ctx context.Context) (map[string]*prom.MetricFamily, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.Config.Url, nil)
 	if err != nil {
 		s.Logger.WithError(err).Warn("failed to create request")
-		return []byte{}
+		return nil, 0, err
 	}
 	req.Header.Set("Accept", "text/plain")
 	resp, err := s.HttpClient.Do(req)
 	if err != nil {
 		s.Logger.WithError(err).Info("Failed to fetch upstream source")
-		return []byte{}
+		return nil, 0, err
 	}
-	return s.ParseAndLabelMetrics(resp.Body)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("upstream source returned %s", resp.Status)
+		s.Logger.WithError(err).Info("Failed to fetch upstream source")
+		return nil, 0, err
+	}
+	return s.parseAndLabelMetrics(resp.Body)
 }
 
 func (s *MetricsSource) ParseAndLabelMetrics(in io.Reader) []byte {
 	var buffer bytes.Buffer
-	mf, err := s.Parser.TextToMetricFamilies(in)
+	families, _, err := s.parseAndLabelMetrics(in)
 	if err != nil {
-		s.Logger.WithError(err).Info("Failed to read upstream or parse metrics")
 		return buffer.Bytes()
 	}
+	set := newFamilySet()
+	for _, family := range families {
+		_, _ = set.add(family)
+	}
+	if err := set.write(&buffer); err != nil {
+		s.Logger.WithError(err).Info("Failed to write out metric family")
+	}
+	return buffer.Bytes()
+}
+
+func (s *MetricsSource) parseAndLabelMetrics(in io.Reader) (map[string]*prom.MetricFamily, int, error) {
+	// parsers hold state, so sources scraped concurrently can't share one
+	var parser expfmt.TextParser
+	mf, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		s.Logger.WithError(err).Info("Failed to read upstream or parse metrics")
+		return nil, 0, err
+	}
 	for _, v := range mf {
 		for _, metric := range v.Metric {
-			metric.Label = append(s.Config.LabelsToAttach, metric.Label...)
+			labels := make([]*prom.LabelPair, 0, len(s.Config.LabelsToAttach)+len(metric.Label))
+			metric.Label = append(append(labels, s.Config.LabelsToAttach...), metric.Label...)
 		}
-		_, err := expfmt.MetricFamilyToText(&buffer, v)
-		if err != nil {
-			s.Logger.WithError(err).Info("Failed to write out metric family")
-			return buffer.Bytes()
+	}
+	families := s.Relabeler.Apply(mf)
+	samples := 0
+	for _, family := range families {
+		samples += sampleCount(family)
+	}
+	return families, samples, nil
+}
+
+// sampleCount is the number of lines a family is exposed as, excluding comments
+func sampleCount(family *prom.MetricFamily) int {
+	count := 0
+	for _, metric := range family.Metric {
+		switch {
+		case metric.Histogram != nil:
+			// buckets, _sum and _count, plus the +Inf bucket written when missing
+			buckets := metric.Histogram.Bucket
+			count += len(buckets) + 2
+			if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), +1) {
+				count++
+			}
+		case metric.Summary != nil:
+			count += len(metric.Summary.Quantile) + 2
+		default:
+			count++
 		}
 	}
-	return buffer.Bytes()
+	return count
 }
@@ -2,10 +2,14 @@ package metrics_endpoint
 
 import (
 	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	io_prometheus_client "github.com/prometheus/client_model/go"
@@ -22,9 +26,7 @@ process_max_fds{old_label="old value"} 1024
 # TYPE process_resident_memory_bytes gauge
 process_resident_memory_bytes 1.0584064e+07
 `)
-	var parser expfmt.TextParser
 	source := MetricsSource{
-		Parser: &parser,
 		Config: MetricsSourceConfig{
 			Url: "",
 			LabelsToAttach: []*io_prometheus_client.LabelPair{
@@ -56,3 +58,149 @@ process_resident_memory_bytes{project="8783",Name="prod-1-abcdef"} 1.0584064e+07
 		t.Fatalf("Failed to relabel metrics; %s != %s", relabeled, expected)
 	}
 }
+
+func TestMetricsSource_Relabeling(t *testing.T) {
+	buffer := bytes.NewBufferString(`# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 8
+# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{path="/rest/v1/todos",internal_id="a1",code="200"} 3
+http_requests_total{path="/auth/v1/token",internal_id="b2",code="500"} 1
+`)
+	empty := ""
+	relabeler, err := NewRelabeler([]RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: RelabelDrop},
+		{SourceLabels: []string{"path"}, Regex: "/(\\w+)/v1/.*", TargetLabel: "api"},
+		{SourceLabels: []string{"path"}, Replacement: &empty, TargetLabel: "path"},
+		{Regex: "internal_.*", Action: RelabelLabelDrop},
+		{SourceLabels: []string{"api"}, Modulus: 4, TargetLabel: "shard", Action: RelabelHashMod},
+		{SourceLabels: []string{"__name__", "code"}, Regex: "http_requests_total;5..", Replacement: aws.String("http_errors_total"), TargetLabel: "__name__"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := MetricsSource{
+		Config: MetricsSourceConfig{
+			LabelsToAttach: []*io_prometheus_client.LabelPair{{Name: aws.String("project"), Value: aws.String("8783")}},
+		},
+		Logger:    logrus.New(),
+		Relabeler: relabeler,
+	}
+	metrics := source.ParseAndLabelMetrics(buffer)
+
+	expected := strings.Split(`# HELP http_errors_total Requests served.
+# TYPE http_errors_total counter
+http_errors_total{project="8783",code="500",api="auth",shard="1"} 1
+# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{project="8783",code="200",api="rest",shard="0"} 3
+`, "\n")
+	relabeled := strings.Split(string(metrics), "\n")
+	sort.Strings(relabeled)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(relabeled, expected) {
+		t.Fatalf("Failed to relabel metrics; %s != %s", relabeled, expected)
+	}
+	if source.Config.LabelsToAttach[0].GetValue() != "8783" || len(source.Config.LabelsToAttach) != 1 {
+		t.Fatalf("relabeling changed the labels to attach: %v", source.Config.LabelsToAttach)
+	}
+
+	for _, config := range []RelabelConfig{
+		{Action: "labelmap"},
+		{Regex: "(", Action: RelabelKeep},
+		{SourceLabels: []string{"a"}},
+		{TargetLabel: "shard", Action: RelabelHashMod},
+		{SourceLabels: []string{"a"}, Regex: "a", Action: RelabelLabelKeep},
+	} {
+		if _, err := NewRelabeler([]RelabelConfig{config}); err == nil {
+			t.Errorf("expected %+v to be rejected", config)
+		}
+	}
+}
+
+func TestMetrics_MergeFamilies(t *testing.T) {
+	exporter := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprint(w, body)
+		}))
+	}
+	db := exporter(`# HELP process_resident_memory_bytes Resident memory size in bytes.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 2e+07
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="500"} 1
+requests_total{code="200"} 7
+`)
+	defer db.Close()
+	rest := exporter(`# HELP process_resident_memory_bytes Resident memory of the process.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 1e+07
+# TYPE requests_total gauge
+requests_total 3
+# TYPE up gauge
+up 1
+`)
+	defer rest.Close()
+
+	client := http.Client{Timeout: time.Second}
+	source := func(name string, url string, labels ...*io_prometheus_client.LabelPair) MetricsSource {
+		return MetricsSource{
+			Config:     MetricsSourceConfig{Name: name, Url: url, LabelsToAttach: labels},
+			HttpClient: &client,
+			Logger:     logrus.New(),
+		}
+	}
+	metrics := Metrics{Sources: []MetricsSource{
+		source("db", db.URL, &io_prometheus_client.LabelPair{Name: aws.String("service"), Value: aws.String("db")}),
+		source("rest", rest.URL, &io_prometheus_client.LabelPair{Name: aws.String("service"), Value: aws.String("rest")}),
+		// exports the same series as rest, which are dropped
+		source("rest-again", rest.URL, &io_prometheus_client.LabelPair{Name: aws.String("service"), Value: aws.String("rest")}),
+	}}
+	lines := strings.Split(metrics.GetMergedMetrics(), "\n")
+	merged := make([]string, 0, len(lines))
+	for _, line := range lines {
+		// durations vary
+		if !strings.HasPrefix(line, "adminapi_upstream_scrape_duration_seconds{") {
+			merged = append(merged, line)
+		}
+	}
+
+	expected := strings.Split(`# HELP adminapi_upstream_samples Samples exported by the upstream source in its last scrape, after relabeling
+# TYPE adminapi_upstream_samples gauge
+adminapi_upstream_samples{source="db"} 3
+adminapi_upstream_samples{source="rest"} 3
+adminapi_upstream_samples{source="rest-again"} 3
+# HELP adminapi_upstream_scrape_duration_seconds How long the last scrape of the upstream source took
+# TYPE adminapi_upstream_scrape_duration_seconds gauge
+# HELP adminapi_upstream_type_conflicts Families of the upstream source dropped in its last scrape for having another type than the same family of a previous source
+# TYPE adminapi_upstream_type_conflicts gauge
+adminapi_upstream_type_conflicts{source="db"} 0
+adminapi_upstream_type_conflicts{source="rest"} 1
+adminapi_upstream_type_conflicts{source="rest-again"} 1
+# HELP adminapi_upstream_up Whether the last scrape of the upstream source succeeded
+# TYPE adminapi_upstream_up gauge
+adminapi_upstream_up{source="db"} 1
+adminapi_upstream_up{source="rest"} 1
+adminapi_upstream_up{source="rest-again"} 1
+# HELP process_resident_memory_bytes Resident memory size in bytes.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes{service="db"} 2e+07
+process_resident_memory_bytes{service="rest"} 1e+07
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{service="db",code="200"} 7
+requests_total{service="db",code="500"} 1
+# TYPE up gauge
+up{service="rest"} 1
+`, "\n")
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("unexpected merged metrics:\n%s", strings.Join(merged, "\n"))
+	}
+
+	var parser expfmt.TextParser
+	if _, err := parser.TextToMetricFamilies(strings.NewReader(metrics.GetMergedMetrics())); err != nil {
+		t.Fatalf("merged metrics aren't valid: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package metrics_endpoint
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	prom "github.com/prometheus/client_model/go"
+)
+
+// Relabeling actions, as in Prometheus' metric_relabel_configs
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelHashMod   = "hashmod"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+)
+
+// metricNameLabel holds the name of the metric family while relabeling
+const metricNameLabel = "__name__"
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// RelabelConfig is a rule of metric_relabel_configs, with Prometheus' defaults and semantics. Rules
+// see a sample's family name as __name__; histogram and summary buckets aren't labels
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	// Separator joins the values of SourceLabels, ";" by default
+	Separator string `yaml:"separator"`
+	// Regex is anchored at both ends, "(.*)" by default
+	Regex       string `yaml:"regex"`
+	Modulus     uint64 `yaml:"modulus"`
+	TargetLabel string `yaml:"target_label"`
+	// Replacement is "$1" by default; an empty replacement removes TargetLabel
+	Replacement *string `yaml:"replacement"`
+	// Action is replace by default
+	Action string `yaml:"action"`
+}
+
+type relabelRule struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+// Relabeler applies a source's rules to every sample it exports; a nil Relabeler leaves them as they are
+type Relabeler struct {
+	rules []relabelRule
+}
+
+// NewRelabeler validates rules and fills in their defaults
+func NewRelabeler(configs []RelabelConfig) (*Relabeler, error) {
+	relabeler := &Relabeler{}
+	for i, config := range configs {
+		if config.Action == "" {
+			config.Action = RelabelReplace
+		}
+		if config.Separator == "" {
+			config.Separator = ";"
+		}
+		if config.Regex == "" {
+			config.Regex = "(.*)"
+		}
+		if config.Replacement == nil {
+			replacement := "$1"
+			config.Replacement = &replacement
+		}
+		regex, err := regexp.Compile("^(?:" + config.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("metric_relabel_configs[%d]: invalid regex: %v", i, err)
+		}
+
+		switch config.Action {
+		case RelabelReplace, RelabelHashMod:
+			if config.TargetLabel == "" {
+				return nil, fmt.Errorf("metric_relabel_configs[%d]: %s requires target_label", i, config.Action)
+			}
+			if config.Action == RelabelHashMod && config.Modulus == 0 {
+				return nil, fmt.Errorf("metric_relabel_configs[%d]: hashmod requires a modulus", i)
+			}
+		case RelabelKeep, RelabelDrop:
+		case RelabelLabelDrop, RelabelLabelKeep:
+			if len(config.SourceLabels) > 0 || config.TargetLabel != "" {
+				return nil, fmt.Errorf("metric_relabel_configs[%d]: %s only takes a regex", i, config.Action)
+			}
+		default:
+			return nil, fmt.Errorf("metric_relabel_configs[%d]: unknown action %q", i, config.Action)
+		}
+		relabeler.rules = append(relabeler.rules, relabelRule{RelabelConfig: config, regex: regex})
+	}
+	return relabeler, nil
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type labelSet []label
+
+func (l labelSet) get(name string) string {
+	for _, label := range l {
+		if label.name == name {
+			return label.value
+		}
+	}
+	return ""
+}
+
+// set keeps the position of an existing label, and removes it when the value is empty
+func (l labelSet) set(name string, value string) labelSet {
+	for i := range l {
+		if l[i].name == name {
+			if value == "" {
+				return append(l[:i], l[i+1:]...)
+			}
+			l[i].value = value
+			return l
+		}
+	}
+	if value == "" {
+		return l
+	}
+	return append(l, label{name: name, value: value})
+}
+
+// relabel returns the labels left by the rules, or false if the sample is dropped
+func (r *Relabeler) relabel(labels labelSet) (labelSet, bool) {
+	for _, rule := range r.rules {
+		values := make([]string, len(rule.SourceLabels))
+		for i, name := range rule.SourceLabels {
+			values[i] = labels.get(name)
+		}
+		value := strings.Join(values, rule.Separator)
+
+		switch rule.Action {
+		case RelabelKeep:
+			if !rule.regex.MatchString(value) {
+				return nil, false
+			}
+		case RelabelDrop:
+			if rule.regex.MatchString(value) {
+				return nil, false
+			}
+		case RelabelReplace:
+			indexes := rule.regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+			target := string(rule.regex.ExpandString(nil, rule.TargetLabel, value, indexes))
+			if !labelName.MatchString(target) {
+				continue
+			}
+			labels = labels.set(target, string(rule.regex.ExpandString(nil, *rule.Replacement, value, indexes)))
+		case RelabelHashMod:
+			sum := md5.Sum([]byte(value))
+			labels = labels.set(rule.TargetLabel, fmt.Sprint(binary.BigEndian.Uint64(sum[8:])%rule.Modulus))
+		case RelabelLabelDrop, RelabelLabelKeep:
+			// the metric's name isn't subject to either
+			kept := labels[:0]
+			for _, label := range labels {
+				if label.name == metricNameLabel || rule.regex.MatchString(label.name) == (rule.Action == RelabelLabelKeep) {
+					kept = append(kept, label)
+				}
+			}
+			labels = kept
+		}
+	}
+	return labels, true
+}
+
+// Apply relabels every sample of the families, moving samples to another family when their
+// __name__ was replaced. Samples left without a valid name are dropped, as are samples renamed
+// into a family of another type
+func (r *Relabeler) Apply(families map[string]*prom.MetricFamily) map[string]*prom.MetricFamily {
+	if r == nil || len(r.rules) == 0 {
+		return families
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type renamedMetric struct {
+		name   string
+		family *prom.MetricFamily
+		metric *prom.Metric
+	}
+	relabeled := make(map[string]*prom.MetricFamily, len(families))
+	add := func(name string, family *prom.MetricFamily, metric *prom.Metric) {
+		target, ok := relabeled[name]
+		if !ok {
+			target = &prom.MetricFamily{Name: stringPointer(name), Help: family.Help, Type: family.Type}
+			relabeled[name] = target
+		} else if target.GetType() != family.GetType() {
+			return
+		}
+		target.Metric = append(target.Metric, metric)
+	}
+	// renamed samples are added last, so that a family's own samples decide its type
+	renamed := make([]renamedMetric, 0)
+	for _, name := range names {
+		family := families[name]
+		for _, metric := range family.Metric {
+			labels := labelSet{{name: metricNameLabel, value: name}}
+			for _, pair := range metric.Label {
+				labels = append(labels, label{name: pair.GetName(), value: pair.GetValue()})
+			}
+			labels, keep := r.relabel(labels)
+			if !keep {
+				continue
+			}
+			newName := labels.get(metricNameLabel)
+			if !metricName.MatchString(newName) {
+				continue
+			}
+
+			// labels are rebuilt rather than edited, as the pairs attached to every sample are shared
+			metric.Label = make([]*prom.LabelPair, 0, len(labels)-1)
+			for _, label := range labels {
+				if label.name != metricNameLabel {
+					metric.Label = append(metric.Label, &prom.LabelPair{Name: stringPointer(label.name), Value: stringPointer(label.value)})
+				}
+			}
+			if newName == name {
+				add(name, family, metric)
+			} else {
+				renamed = append(renamed, renamedMetric{name: newName, family: family, metric: metric})
+			}
+		}
+	}
+	for _, metric := range renamed {
+		add(metric.name, metric.family, metric.metric)
+	}
+	return relabeled
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
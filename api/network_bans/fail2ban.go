@@ -61,3 +61,38 @@ func (n *Fail2Ban) UnbanIp(jails []string, ipAddr string) error {
 	}
 	return nil
 }
+
+// JailStatus is the state of a fail2ban jail
+type JailStatus struct {
+	Jail            string   `json:"jail"`
+	CurrentlyFailed int64    `json:"currently_failed"`
+	TotalFailed     int64    `json:"total_failed"`
+	CurrentlyBanned int64    `json:"currently_banned"`
+	TotalBanned     int64    `json:"total_banned"`
+	BannedIps       []string `json:"banned_ips"`
+}
+
+// Status returns the state of every jail
+func (n *Fail2Ban) Status() ([]JailStatus, error) {
+	conn := fail2go.Newfail2goConn(n.Fail2banSocket)
+	jails, err := conn.GlobalStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get global status")
+	}
+	statuses := make([]JailStatus, 0, len(jails))
+	for _, jail := range jails {
+		currentlyFailed, totalFailed, _, currentlyBanned, totalBanned, ipList, err := conn.JailStatus(jail)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get jail %s status", jail)
+		}
+		statuses = append(statuses, JailStatus{
+			Jail:            jail,
+			CurrentlyFailed: currentlyFailed,
+			TotalFailed:     totalFailed,
+			CurrentlyBanned: currentlyBanned,
+			TotalBanned:     totalBanned,
+			BannedIps:       ipList,
+		})
+	}
+	return statuses, nil
+}
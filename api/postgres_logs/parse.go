@@ -0,0 +1,327 @@
+package postgres_logs
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CSVLog is the format of the csvlog log destination
+	CSVLog = "csvlog"
+	// Stderr is the format of the stderr log destination, each line starting with log_line_prefix
+	Stderr = "stderr"
+)
+
+// DefaultLinePrefix is PostgreSQL's default log_line_prefix
+const DefaultLinePrefix = "%m [%p] "
+
+// IsSupported reports whether log files of a format can be parsed
+func IsSupported(format string) bool {
+	return format == CSVLog || format == Stderr
+}
+
+// severities ranks PostgreSQL's message severities from least to most important
+var severities = []string{"DEBUG5", "DEBUG4", "DEBUG3", "DEBUG2", "DEBUG1", "DEBUG", "INFO", "NOTICE", "LOG", "WARNING", "ERROR", "FATAL", "PANIC"}
+
+// SeverityRank orders severities, returning -1 for unknown ones
+func SeverityRank(severity string) int {
+	severity = strings.ToUpper(severity)
+	for rank, name := range severities {
+		if name == severity {
+			return rank
+		}
+	}
+	return -1
+}
+
+// Entry is a single message of the server log
+type Entry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Severity    string    `json:"severity"`
+	SQLState    string    `json:"sqlstate,omitempty"`
+	PID         int       `json:"pid,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Database    string    `json:"database,omitempty"`
+	Application string    `json:"application,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	Message     string    `json:"message"`
+	Detail      string    `json:"detail,omitempty"`
+	Hint        string    `json:"hint,omitempty"`
+	Context     string    `json:"context,omitempty"`
+	Statement   string    `json:"statement,omitempty"`
+	// Duration is in milliseconds, for statements logged by log_min_duration_statement or
+	// log_duration
+	Duration *float64 `json:"duration_ms,omitempty"`
+	// Redacted is the number of secrets masked in the entry
+	Redacted int `json:"redacted,omitempty"`
+}
+
+var durationMessage = regexp.MustCompile(`^duration: ([0-9.]+) ms(?:\s+(?:statement|execute [^:]*|parse [^:]*|bind [^:]*):\s*(.*))?$`)
+
+// parseDuration pulls the duration, and the statement if there is one, out of a duration message
+func (e *Entry) parseDuration() {
+	match := durationMessage.FindStringSubmatch(strings.TrimSpace(e.Message))
+	if match == nil {
+		return
+	}
+	if duration, err := strconv.ParseFloat(match[1], 64); err == nil {
+		e.Duration = &duration
+	}
+	if match[2] != "" && e.Statement == "" {
+		e.Statement = match[2]
+	}
+}
+
+var timestampLayouts = []string{"2006-01-02 15:04:05.000 MST", "2006-01-02 15:04:05 MST"}
+
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMicro(int64(seconds * 1e6)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown timestamp %q", value)
+}
+
+// csvlog columns, as of PostgreSQL 12; later versions only append columns
+const (
+	csvTime = iota
+	csvUser
+	csvDatabase
+	csvPID
+	csvConnectionFrom
+	csvSessionID
+	csvSessionLine
+	csvCommandTag
+	csvSessionStart
+	csvVirtualTransaction
+	csvTransaction
+	csvSeverity
+	csvSQLState
+	csvMessage
+	csvDetail
+	csvHint
+	csvInternalQuery
+	csvInternalQueryPos
+	csvContext
+	csvQuery
+	csvQueryPos
+	csvLocation
+	csvApplication
+	csvColumns
+)
+
+// ParseCSV reads a csvlog file, calling fn with every entry in order
+func ParseCSV(r io.Reader, fn func(Entry)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < csvColumns {
+			continue
+		}
+		timestamp, err := parseTimestamp(record[csvTime])
+		if err != nil {
+			continue
+		}
+		entry := Entry{
+			Timestamp:   timestamp,
+			Severity:    record[csvSeverity],
+			SQLState:    record[csvSQLState],
+			User:        record[csvUser],
+			Database:    record[csvDatabase],
+			Application: record[csvApplication],
+			Host:        hostOf(record[csvConnectionFrom]),
+			Message:     record[csvMessage],
+			Detail:      record[csvDetail],
+			Hint:        record[csvHint],
+			Context:     record[csvContext],
+			Statement:   record[csvQuery],
+		}
+		entry.PID, _ = strconv.Atoi(record[csvPID])
+		entry.parseDuration()
+		fn(entry)
+	}
+}
+
+// hostOf strips the port off a connection_from, host:port
+func hostOf(connectionFrom string) string {
+	if i := strings.LastIndex(connectionFrom, ":"); i > 0 {
+		return connectionFrom[:i]
+	}
+	return connectionFrom
+}
+
+// prefixEscapes are the log_line_prefix escapes, as regular expressions capturing their value
+var prefixEscapes = map[byte]string{
+	'a': `(?P<a>.*?)`,
+	'u': `(?P<u>\S*?)`,
+	'd': `(?P<d>\S*?)`,
+	'r': `(?P<r>\S*?)`,
+	'h': `(?P<h>\S*?)`,
+	'b': `\S*?`,
+	'p': `(?P<p>\d+)`,
+	'P': `\d*`,
+	't': `(?P<t>\d{4}-\d\d-\d\d \d\d:\d\d:\d\d \S+)`,
+	'm': `(?P<m>\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d+ \S+)`,
+	'n': `(?P<n>\d+\.\d+)`,
+	'i': `\S*?`,
+	'e': `(?P<e>[0-9A-Z]{5})`,
+	'c': `\S+`,
+	'l': `\d+`,
+	's': `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d \S+`,
+	'v': `\S*?`,
+	'x': `\d+`,
+	'Q': `-?\d+`,
+}
+
+// compilePrefix turns a log_line_prefix into a regular expression matching the start of a line,
+// followed by the severity of the message
+func compilePrefix(prefix string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	optional := false
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '%' || i == len(prefix)-1 {
+			pattern.WriteString(regexp.QuoteMeta(prefix[i : i+1]))
+			continue
+		}
+		i++
+		switch escape := prefix[i]; escape {
+		case '%':
+			pattern.WriteString("%")
+		case 'q':
+			// the rest only appears for session processes
+			pattern.WriteString("(?:")
+			optional = true
+		default:
+			expression, ok := prefixEscapes[escape]
+			if !ok {
+				return nil, fmt.Errorf("unsupported log_line_prefix escape %%%c", escape)
+			}
+			pattern.WriteString(expression)
+		}
+	}
+	if optional {
+		pattern.WriteString(")?")
+	}
+	pattern.WriteString(`(?P<severity>[A-Z0-9]+):\s+(?:(?P<code>[0-9A-Z]{5}): )?(?P<message>.*)$`)
+	return regexp.Compile(pattern.String())
+}
+
+// ValidateLinePrefix checks a log_line_prefix only uses escapes ParseStderr understands
+func ValidateLinePrefix(prefix string) error {
+	_, err := compilePrefix(prefix)
+	return err
+}
+
+// ParseStderr reads a stderr log file written with the given log_line_prefix, calling fn with every
+// entry in order; DETAIL, HINT, CONTEXT and STATEMENT lines, and continuation lines, are folded into
+// the entry they belong to
+func ParseStderr(r io.Reader, linePrefix string, fn func(Entry)) error {
+	pattern, err := compilePrefix(linePrefix)
+	if err != nil {
+		return err
+	}
+	names := pattern.SubexpNames()
+
+	var current *Entry
+	var lastField *string
+	flush := func() {
+		if current != nil {
+			current.parseDuration()
+			fn(*current)
+		}
+		current, lastField = nil, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := pattern.FindStringSubmatch(line)
+		if match == nil {
+			// a message spanning several lines
+			if lastField != nil {
+				*lastField += "\n" + strings.TrimPrefix(line, "\t")
+			}
+			continue
+		}
+		fields := make(map[string]string)
+		for i, name := range names {
+			if name != "" {
+				fields[name] = match[i]
+			}
+		}
+
+		severity, message := fields["severity"], fields["message"]
+		if current != nil {
+			switch severity {
+			case "DETAIL":
+				current.Detail, lastField = message, &current.Detail
+				continue
+			case "HINT":
+				current.Hint, lastField = message, &current.Hint
+				continue
+			case "CONTEXT":
+				current.Context, lastField = message, &current.Context
+				continue
+			case "STATEMENT":
+				current.Statement, lastField = message, &current.Statement
+				continue
+			case "LOCATION", "QUERY":
+				lastField = nil
+				continue
+			}
+		}
+		if SeverityRank(severity) == -1 {
+			if lastField != nil {
+				*lastField += "\n" + line
+			}
+			continue
+		}
+
+		flush()
+		current = &Entry{
+			Severity:    severity,
+			SQLState:    fields["e"],
+			User:        fields["u"],
+			Database:    fields["d"],
+			Application: fields["a"],
+			Host:        fields["h"],
+			Message:     message,
+		}
+		if current.SQLState == "" {
+			current.SQLState = fields["code"]
+		}
+		if current.Host == "" {
+			current.Host = hostOf(fields["r"])
+		}
+		current.PID, _ = strconv.Atoi(fields["p"])
+		for _, name := range []string{"m", "t", "n"} {
+			if fields[name] != "" {
+				current.Timestamp, _ = parseTimestamp(fields[name])
+				break
+			}
+		}
+		lastField = &current.Message
+	}
+	flush()
+	return scanner.Err()
+}
@@ -0,0 +1,146 @@
+package postgres_logs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const csvLog = `2022-06-01 10:00:00.123 UTC,"postgres","postgres",4242,"10.0.0.1:51234",62973a40.1092,1,"SELECT",2022-06-01 09:59:58 UTC,3/12,0,ERROR,42P01,"relation ""missing"" does not exist",,,,,,"SELECT * FROM missing;",15,,"psql"
+2022-06-01 10:00:01.500 UTC,"authenticator","postgres",4243,"[local]",62973a41.1093,2,"SELECT",2022-06-01 10:00:00 UTC,4/2,0,LOG,00000,"duration: 1502.250 ms  statement: SELECT pg_sleep(1.5);",,,,,,,,,"postgrest"
+2022-06-01 10:00:02.000 UTC,,,4000,,62973a00.fa0,1,,2022-06-01 09:00:00 UTC,,0,LOG,00000,"checkpoint starting: time",,,,,,,,,""
+`
+
+func TestParseCSV(t *testing.T) {
+	entries := make([]Entry, 0)
+	if err := ParseCSV(strings.NewReader(csvLog), func(entry Entry) {
+		entries = append(entries, entry)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+
+	failed := entries[0]
+	if !failed.Timestamp.Equal(time.Date(2022, 6, 1, 10, 0, 0, 123000000, time.UTC)) {
+		t.Errorf("unexpected timestamp %s", failed.Timestamp)
+	}
+	if failed.Severity != "ERROR" || failed.SQLState != "42P01" || failed.User != "postgres" || failed.Database != "postgres" ||
+		failed.PID != 4242 || failed.Host != "10.0.0.1" || failed.Application != "psql" {
+		t.Errorf("unexpected entry %+v", failed)
+	}
+	if failed.Message != `relation "missing" does not exist` || failed.Statement != "SELECT * FROM missing;" {
+		t.Errorf("unexpected message %q or statement %q", failed.Message, failed.Statement)
+	}
+
+	slow := entries[1]
+	if slow.Duration == nil || *slow.Duration != 1502.25 || slow.Statement != "SELECT pg_sleep(1.5);" {
+		t.Errorf("expected the duration and statement to be parsed, got %+v", slow)
+	}
+	if entries[2].Duration != nil || entries[2].User != "" {
+		t.Errorf("unexpected entry %+v", entries[2])
+	}
+}
+
+func TestParseStderr(t *testing.T) {
+	log := `2022-06-01 10:00:00.123 UTC [4242] ERROR:  relation "missing" does not exist at character 15
+2022-06-01 10:00:00.123 UTC [4242] STATEMENT:  SELECT *
+	FROM missing;
+2022-06-01 10:00:01.000 UTC [4243] LOG:  duration: 12.5 ms  statement: SELECT 1
+2022-06-01 10:00:02.000 UTC [4244] FATAL:  password authentication failed for user "app"
+2022-06-01 10:00:02.000 UTC [4244] DETAIL:  Connection matched pg_hba.conf line 5
+`
+	entries := make([]Entry, 0)
+	if err := ParseStderr(strings.NewReader(log), DefaultLinePrefix, func(entry Entry) {
+		entries = append(entries, entry)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	if entries[0].Severity != "ERROR" || entries[0].PID != 4242 || entries[0].Statement != "SELECT *\nFROM missing;" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+	if entries[1].Duration == nil || *entries[1].Duration != 12.5 || entries[1].Statement != "SELECT 1" {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+	if entries[2].Severity != "FATAL" || entries[2].Detail != "Connection matched pg_hba.conf line 5" {
+		t.Errorf("unexpected entry %+v", entries[2])
+	}
+}
+
+func TestParseStderrCustomPrefix(t *testing.T) {
+	log := `2022-06-01 10:00:00 UTC [4242]: user=app,db=shop,app=psql,client=10.0.0.1 ERROR:  42501: permission denied for table orders
+2022-06-01 10:00:01 UTC [4000]: user=,db=,app=,client= LOG:  checkpoint complete
+`
+	entries := make([]Entry, 0)
+	if err := ParseStderr(strings.NewReader(log), "%t [%p]: user=%u,db=%d,app=%a,client=%h ", func(entry Entry) {
+		entries = append(entries, entry)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	denied := entries[0]
+	if denied.User != "app" || denied.Database != "shop" || denied.Application != "psql" || denied.Host != "10.0.0.1" ||
+		denied.SQLState != "42501" || denied.Message != "permission denied for table orders" {
+		t.Errorf("unexpected entry %+v", denied)
+	}
+
+	if err := ParseStderr(strings.NewReader(log), "%z ", func(Entry) {}); err == nil {
+		t.Error("expected an unsupported escape to fail")
+	}
+}
+
+func TestReadAndSummarize(t *testing.T) {
+	dir := t.TempDir()
+	rotated := `2022-06-01 09:00:00.000 UTC,"app","shop",1,,a,1,,,,0,ERROR,23505,"duplicate key value violates unique constraint ""orders_pkey""",,,,,,,,,""
+`
+	if err := os.WriteFile(filepath.Join(dir, "postgresql-1.csv"), []byte(rotated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "postgresql-2.csv"), []byte(csvLog+`2022-06-01 10:00:03.000 UTC,"app","shop",2,,b,1,,,,0,ERROR,42P01,"relation ""other"" does not exist",,,,,,,,,""
+2022-06-01 10:00:04.000 UTC,"app","shop",3,,c,1,,,,0,WARNING,01000,"something odd",,,,,,,,,""
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := Source{Files: filepath.Join(dir, "*.csv"), Format: CSVLog}
+
+	entries, err := Read(source, Query{Severity: "WARNING"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].SQLState != "23505" || entries[3].Severity != "WARNING" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	since := time.Date(2022, 6, 1, 9, 30, 0, 0, time.UTC)
+	entries, err = Read(source, Query{Since: &since, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Message != "something odd" {
+		t.Fatalf("expected the newest 2 entries, got %+v", entries)
+	}
+
+	entries, err = Read(source, Query{Since: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := Summarize(entries)
+	if len(summary) != 1 {
+		t.Fatalf("expected a single group, got %+v", summary)
+	}
+	group := summary[0]
+	if group.SQLState != "42P01" || group.Count != 2 || group.Fingerprint != "relation ? does not exist" {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if !group.FirstSeen.Equal(time.Date(2022, 6, 1, 10, 0, 0, 123000000, time.UTC)) || group.Example.Message != `relation "other" does not exist` {
+		t.Errorf("unexpected first seen %s or example %+v", group.FirstSeen, group.Example)
+	}
+}
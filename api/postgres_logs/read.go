@@ -0,0 +1,184 @@
+package postgres_logs
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultLimit = 100
+const MaxLimit = 1000
+
+// Source is a set of log files in one format
+type Source struct {
+	// Files is a glob matching the log files, rotated ones included
+	Files  string
+	Format string
+	// LinePrefix is the log_line_prefix of stderr logs, DefaultLinePrefix if empty
+	LinePrefix string
+}
+
+// Query selects entries of the server log
+type Query struct {
+	Since *time.Time
+	Until *time.Time
+	// Severity is the least important severity to return, e.g. WARNING; all if empty
+	Severity string
+	// Grep is a regular expression messages have to match
+	Grep  *regexp.Regexp
+	Limit int
+}
+
+func (q *Query) matches(entry *Entry) bool {
+	if q.Since != nil && entry.Timestamp.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && entry.Timestamp.After(*q.Until) {
+		return false
+	}
+	if q.Severity != "" && SeverityRank(entry.Severity) < SeverityRank(q.Severity) {
+		return false
+	}
+	if q.Grep != nil && !q.Grep.MatchString(entry.Message) {
+		return false
+	}
+	return true
+}
+
+// Read returns the newest entries of a source matching a query, oldest first; files last modified
+// before the start of the query are skipped, they can't hold anything newer
+func Read(source Source, query Query) ([]Entry, error) {
+	paths, err := filepath.Glob(source.Files)
+	if err != nil {
+		return nil, err
+	}
+	type logFile struct {
+		path    string
+		modTime time.Time
+	}
+	files := make([]logFile, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if query.Since != nil && info.ModTime().Before(*query.Since) {
+			continue
+		}
+		files = append(files, logFile{path, info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	linePrefix := source.LinePrefix
+	if linePrefix == "" {
+		linePrefix = DefaultLinePrefix
+	}
+	entries := make([]Entry, 0)
+	collect := func(entry Entry) {
+		if query.matches(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+	for _, file := range files {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't open %s", file.path)
+		}
+		if source.Format == Stderr {
+			err = ParseStderr(f, linePrefix, collect)
+		} else {
+			err = ParseCSV(f, collect)
+		}
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse %s", file.path)
+		}
+	}
+
+	// entries in a file are in order, but rotated files can overlap by a few
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[len(entries)-query.Limit:]
+	}
+	return entries, nil
+}
+
+var (
+	quotedLiteral  = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"`)
+	numericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	whitespace     = regexp.MustCompile(`\s+`)
+)
+
+// Fingerprint normalizes a message so that occurrences of the same error with different values,
+// relation names or numbers collapse into one
+func Fingerprint(message string) string {
+	message = quotedLiteral.ReplaceAllString(message, "?")
+	message = numericLiteral.ReplaceAllString(message, "?")
+	return strings.TrimSpace(whitespace.ReplaceAllString(message, " "))
+}
+
+// ErrorGroup counts the occurrences of one kind of error
+type ErrorGroup struct {
+	SQLState    string    `json:"sqlstate"`
+	Severity    string    `json:"severity"`
+	Fingerprint string    `json:"fingerprint"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	// Example is the latest occurrence
+	Example Entry `json:"example"`
+}
+
+// Summarize groups the entries of ERROR severity or above by SQLSTATE and fingerprint, the most
+// frequent first
+func Summarize(entries []Entry) []ErrorGroup {
+	groups := make(map[string]*ErrorGroup)
+	for _, entry := range entries {
+		if SeverityRank(entry.Severity) < SeverityRank("ERROR") {
+			continue
+		}
+		fingerprint := Fingerprint(entry.Message)
+		key := entry.SQLState + "\x00" + fingerprint
+		group, ok := groups[key]
+		if !ok {
+			group = &ErrorGroup{
+				SQLState:    entry.SQLState,
+				Fingerprint: fingerprint,
+				FirstSeen:   entry.Timestamp,
+			}
+			groups[key] = group
+		}
+		group.Count++
+		if SeverityRank(entry.Severity) > SeverityRank(group.Severity) {
+			group.Severity = entry.Severity
+		}
+		if entry.Timestamp.Before(group.FirstSeen) {
+			group.FirstSeen = entry.Timestamp
+		}
+		if !entry.Timestamp.Before(group.LastSeen) {
+			group.LastSeen = entry.Timestamp
+			group.Example = entry
+		}
+	}
+
+	summary := make([]ErrorGroup, 0, len(groups))
+	for _, group := range groups {
+		summary = append(summary, *group)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Count != summary[j].Count {
+			return summary[i].Count > summary[j].Count
+		}
+		return summary[i].LastSeen.After(summary[j].LastSeen)
+	})
+	return summary
+}
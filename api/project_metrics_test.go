@@ -12,7 +12,6 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	io_prometheus_client "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
 	"github.com/sirupsen/logrus"
 	metrics "github.com/supabase/supabase-admin-api/api/metrics_endpoint"
 )
@@ -44,6 +43,18 @@ node_memory_Mapped_bytes 2.45776384e+08
 	return server1, server2
 }
 
+// scrapeResult sorts the lines of merged metrics, leaving out scrape durations, which vary
+func scrapeResult(merged string) []string {
+	result := strings.Split(merged, "\n")
+	for i, line := range result {
+		if strings.HasPrefix(line, "adminapi_upstream_scrape_duration_seconds{") {
+			result[i] = line[:strings.LastIndex(line, " ")]
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
 func TestUpstreamMetricsEndpoint(t *testing.T) {
 	s1, s2 := upstreamServers()
 	defer s1.Close()
@@ -51,7 +62,6 @@ func TestUpstreamMetricsEndpoint(t *testing.T) {
 	client := http.Client{
 		Timeout: 1 * time.Second,
 	}
-	var parser expfmt.TextParser
 	metricsProvider := metrics.Metrics{
 		Sources: []metrics.MetricsSource{{
 			Config: metrics.MetricsSourceConfig{
@@ -67,7 +77,6 @@ func TestUpstreamMetricsEndpoint(t *testing.T) {
 			},
 			HttpClient: &client,
 			Logger:     logrus.New(),
-			Parser:     &parser,
 		}, {
 			Config: metrics.MetricsSourceConfig{
 				Name: "middleware_system_metrics",
@@ -82,11 +91,9 @@ func TestUpstreamMetricsEndpoint(t *testing.T) {
 			},
 			HttpClient: &client,
 			Logger:     logrus.New(),
-			Parser:     &parser,
 		}},
 	}
-	result := strings.Split(metricsProvider.GetMergedMetrics(), "\n")
-	sort.Strings(result)
+	result := scrapeResult(metricsProvider.GetMergedMetrics())
 	expectedResult := strings.Split(`# HELP process_max_fds Maximum number of open file descriptors.
 # TYPE process_max_fds gauge
 process_max_fds{project="12345",Name="prod-db-ref",k="v"} 1024
@@ -99,6 +106,22 @@ node_memory_KernelStack_bytes{project="12345",Name="prod-1-ref"} 3.887104e+06
 # HELP node_memory_Mapped_bytes Memory information field Mapped_bytes.
 # TYPE node_memory_Mapped_bytes gauge
 node_memory_Mapped_bytes{project="12345",Name="prod-1-ref"} 2.45776384e+08
+# HELP adminapi_upstream_up Whether the last scrape of the upstream source succeeded
+# TYPE adminapi_upstream_up gauge
+adminapi_upstream_up{source="db_system_metrics"} 1
+adminapi_upstream_up{source="middleware_system_metrics"} 1
+# HELP adminapi_upstream_scrape_duration_seconds How long the last scrape of the upstream source took
+# TYPE adminapi_upstream_scrape_duration_seconds gauge
+adminapi_upstream_scrape_duration_seconds{source="db_system_metrics"}
+adminapi_upstream_scrape_duration_seconds{source="middleware_system_metrics"}
+# HELP adminapi_upstream_samples Samples exported by the upstream source in its last scrape, after relabeling
+# TYPE adminapi_upstream_samples gauge
+adminapi_upstream_samples{source="db_system_metrics"} 2
+adminapi_upstream_samples{source="middleware_system_metrics"} 2
+# HELP adminapi_upstream_type_conflicts Families of the upstream source dropped in its last scrape for having another type than the same family of a previous source
+# TYPE adminapi_upstream_type_conflicts gauge
+adminapi_upstream_type_conflicts{source="db_system_metrics"} 0
+adminapi_upstream_type_conflicts{source="middleware_system_metrics"} 0
 `, "\n")
 	sort.Strings(expectedResult)
 
@@ -106,3 +129,54 @@ node_memory_Mapped_bytes{project="12345",Name="prod-1-ref"} 2.45776384e+08
 		t.Fatalf("expected '%s' to equal '%s'", result, expectedResult)
 	}
 }
+
+func TestUpstreamMetricsDeadline(t *testing.T) {
+	s1, _ := upstreamServers()
+	defer s1.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	source := func(name string, url string) metrics.MetricsSource {
+		return metrics.MetricsSource{
+			Config:     metrics.MetricsSourceConfig{Name: name, Url: url},
+			HttpClient: &client,
+			Logger:     logrus.New(),
+		}
+	}
+	metricsProvider := metrics.Metrics{
+		Sources: []metrics.MetricsSource{source("db", s1.URL), source("failing", failing.URL), source("slow", slow.URL)},
+		Timeout: 200 * time.Millisecond,
+	}
+	start := time.Now()
+	result := scrapeResult(metricsProvider.GetMergedMetrics())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("merging took %s despite the deadline", elapsed)
+	}
+
+	for _, expected := range []string{
+		`process_max_fds{k="v"} 1024`,
+		`adminapi_upstream_up{source="db"} 1`,
+		`adminapi_upstream_up{source="failing"} 0`,
+		`adminapi_upstream_up{source="slow"} 0`,
+		`adminapi_upstream_samples{source="db"} 2`,
+		`adminapi_upstream_samples{source="slow"} 0`,
+		`adminapi_upstream_scrape_duration_seconds{source="slow"}`,
+	} {
+		i := sort.SearchStrings(result, expected)
+		if i == len(result) || result[i] != expected {
+			t.Errorf("expected %q in %q", expected, result)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/supabase/supabase-admin-api/api/config_edit"
+	"github.com/supabase/supabase-admin-api/api/config_redaction"
+	"github.com/supabase/supabase-admin-api/api/config_validation"
+	"github.com/supabase/supabase-admin-api/api/postgres_logs"
+)
+
+const SysService string = "services.slice"
+
+// secretEnvKeys match the gotrue and realtime settings holding secrets and credentials
+var secretEnvKeys = []string{`SECRET`, `PASSWORD`, `_PASS$`, `_KEY$`, `_TOKEN$`, `DATABASE_URL$`}
+
+// secretWalgSettings are the wal-g settings holding storage credentials and encryption keys
+var secretWalgSettings = []string{
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AZURE_STORAGE_ACCESS_KEY",
+	"AZURE_STORAGE_SAS_TOKEN",
+	"PGPASSWORD",
+	"WALG_LIBSODIUM_KEY",
+	"WALG_PGP_KEY",
+	"WALG_PGP_KEY_PASSPHRASE",
+}
+
+// secretPostgrestKeys match the postgrest settings holding the database credentials and the JWT
+// secret
+var secretPostgrestKeys = []string{`^db-uri$`, `^db-anon-role$`, `^jwt-secret$`, `password`}
+
+// secretAdminAPISettings are the admin API settings holding its JWT secret, and URLs that can carry
+// credentials in their user info or query
+var secretAdminAPISettings = []string{
+	"jwt_secret",
+	"monitoring.config_drift.event_webhook",
+	"upstream_metrics_sources.*.url",
+}
+
+// databaseApplications are restarted before every service connecting to the database
+var databaseApplications = []string{"postgresql", "pgbouncer"}
+
+// DefaultsConfig holds the bits of the admin API config the built-in applications depend on
+type DefaultsConfig struct {
+	RealtimeServiceName  string
+	GotrueHealthEndpoint string
+	PostgrestEndpoint    string
+	// KongErrorLog is the error log Kong writes to, tailed by the kong log monitor
+	KongErrorLog string
+}
+
+// Defaults returns the applications managed out of the box on a Supabase instance
+func Defaults(config DefaultsConfig) map[string]*Application {
+	postgresqlUnit := derivePostgresqlUnitName()
+	realtimeUnit := fmt.Sprintf("%s.service", config.RealtimeServiceName)
+
+	return map[string]*Application{
+		"test": {
+			Config: &ConfigFile{Path: "./README.md", OldPath: "./old.README.md"},
+			Reload: None,
+		},
+		"gotrue": {
+			Config:         &ConfigFile{Path: "/etc/gotrue.env", OldPath: "/etc/old.gotrue.env"},
+			Validator:      config_validation.Env,
+			Format:         config_edit.Env,
+			Redact:         &config_redaction.Rules{Keys: secretEnvKeys},
+			Unit:           "gotrue.service",
+			HealthEndpoint: config.GotrueHealthEndpoint,
+			Logs:           &LogSource{Unit: "gotrue.service"},
+			After:          databaseApplications,
+		},
+		"postgrest": {
+			Config:         &ConfigFile{Path: "/etc/postgrest/base.conf", OldPath: "/etc/postgrest/old.base.conf"},
+			Format:         config_edit.Postgrest,
+			Redact:         &config_redaction.Rules{Keys: secretPostgrestKeys},
+			Unit:           "postgrest.service",
+			HealthEndpoint: config.PostgrestEndpoint,
+			Logs:           &LogSource{Unit: "postgrest.service"},
+			After:          databaseApplications,
+		},
+		"pglisten": {
+			Config: &ConfigFile{Path: "/etc/pg_listen.conf", OldPath: "/etc/old.pg_listen.conf"},
+			Unit:   "pglisten.service",
+			Logs:   &LogSource{Unit: "pglisten.service"},
+			After:  databaseApplications,
+		},
+		"kong": {
+			Config:    &ConfigFile{Path: "/etc/kong/kong.yml", OldPath: "/etc/kong/old.kong.yml"},
+			Validator: config_validation.Kong,
+			Unit:      "kong.service",
+			Logs:      &LogSource{Unit: "kong.service"},
+			After:     []string{"gotrue", "postgrest", "realtime", "pglisten"},
+		},
+		"kong-error": {
+			Logs: &LogSource{Files: config.KongErrorLog, FileFormat: TextLogs},
+		},
+		"realtime": {
+			Config:    &ConfigFile{Path: "/etc/realtime.env", OldPath: "/etc/old.realtime.env"},
+			Validator: config_validation.Env,
+			Format:    config_edit.Env,
+			Redact:    &config_redaction.Rules{Keys: secretEnvKeys},
+			Unit:      realtimeUnit,
+			Logs:      &LogSource{Unit: realtimeUnit},
+			After:     databaseApplications,
+		},
+		"adminapi": {
+			Aliases: []string{"admin"},
+			Config:  &ConfigFile{Path: "/etc/adminapi/adminapi.yaml", OldPath: "/etc/adminapi/old.adminapi.yaml"},
+			Redact:  &config_redaction.Rules{YAMLPaths: secretAdminAPISettings},
+			Unit:    "adminapi.service",
+			Async:   true,
+			Logs:    &LogSource{Unit: "adminapi.service"},
+			After:   []string{"kong"},
+		},
+		"walg": {
+			Config:    &ConfigFile{Path: "/etc/wal-g/config.json", OldPath: "/etc/wal-g/old.config.json"},
+			Validator: config_validation.Walg,
+			Redact:    &config_redaction.Rules{JSONPaths: secretWalgSettings},
+			Reload:    None,
+		},
+		"postgresql": {
+			Config:    &ConfigFile{Path: "/etc/postgresql-custom/custom-overrides.conf", OldPath: "/etc/postgresql-custom/old.custom-overrides.conf"},
+			Validator: config_validation.Postgresql,
+			Format:    config_edit.Postgresql,
+			Unit:      postgresqlUnit,
+			Logs:      &LogSource{Unit: postgresqlUnit, Files: "/var/log/postgresql/*.csv", FileFormat: postgres_logs.CSVLog},
+		},
+		"pgbouncer": {
+			Config:    &ConfigFile{Path: "/etc/pgbouncer-custom/custom-overrides.ini", OldPath: "/etc/pgbouncer-custom/old.custom-overrides.ini"},
+			Validator: config_validation.Ini,
+			Format:    config_edit.Ini,
+			Unit:      "pgbouncer.service",
+			Logs:      &LogSource{Unit: "pgbouncer.service"},
+			After:     []string{"postgresql"},
+		},
+		"pgsodium": {
+			Config:    &ConfigFile{Path: "/etc/postgresql-custom/pgsodium_root.key", OldPath: "/etc/postgresql-custom/old.pgsodium_root.key"},
+			Validator: config_validation.Pgsodium,
+			Redact:    &config_redaction.Rules{WholeFile: true},
+			Unit:      postgresqlUnit,
+		},
+		"syslog": {
+			Logs: &LogSource{Unit: SysService},
+		},
+	}
+}
+
+func derivePostgresqlUnitName() string {
+	_, err := os.Stat("/etc/postgresql/postgresql.conf")
+	if err != nil {
+		return "postgresql@12-main.service"
+	} else {
+		return "postgresql.service"
+	}
+}
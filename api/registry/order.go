@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// restartOrder sorts every application so that each comes after the applications it lists in
+// After, breaking ties by name; applications missing from the registry are ignored, so disabling
+// one doesn't break the ordering of the others
+func restartOrder(applications map[string]*Application) ([]string, error) {
+	dependents := make(map[string][]string)
+	pending := make(map[string]int)
+	for name := range applications {
+		pending[name] = 0
+	}
+	for name, app := range applications {
+		for _, after := range app.After {
+			if _, ok := applications[after]; !ok || after == name {
+				continue
+			}
+			dependents[after] = append(dependents[after], name)
+			pending[name]++
+		}
+	}
+
+	ready := make([]string, 0)
+	for name, count := range pending {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+	order := make([]string, 0, len(applications))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			if pending[dependent]--; pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(applications) {
+		cyclic := make([]string, 0)
+		for name, count := range pending {
+			if count > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("restart order of %s is cyclic", strings.Join(cyclic, ", "))
+	}
+	return order, nil
+}
+
+// InRestartOrder sorts apps so that every application comes after the ones it depends on. Async
+// applications go last whatever their dependencies, as restarting one, e.g. the admin API itself,
+// can interrupt whatever comes after it
+func (r *Registry) InRestartOrder(apps []*Application) []*Application {
+	sorted := append([]*Application{}, apps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Async != sorted[j].Async {
+			return !sorted[i].Async
+		}
+		return r.order[sorted[i].Name] < r.order[sorted[j].Name]
+	})
+	return sorted
+}
@@ -0,0 +1,301 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/supabase/supabase-admin-api/api/config_edit"
+	"github.com/supabase/supabase-admin-api/api/config_redaction"
+	"github.com/supabase/supabase-admin-api/api/config_validation"
+	"github.com/supabase/supabase-admin-api/api/postgres_logs"
+)
+
+type ReloadStrategy = string
+
+const (
+	Restart ReloadStrategy = "restart"
+	Reload  ReloadStrategy = "reload"
+	None    ReloadStrategy = "none"
+)
+
+const DefaultFileMode = "0664"
+
+// ConfigFile describes a config file managed through the admin API
+type ConfigFile struct {
+	Path    string `yaml:"path"`
+	OldPath string `yaml:"old_path" required:"false"`
+	Mode    string `yaml:"mode" required:"false"`
+	Owner   string `yaml:"owner" required:"false"`
+	Group   string `yaml:"group" required:"false"`
+}
+
+// BackupPath is where the previous version of the file is kept on every write
+func (c *ConfigFile) BackupPath() string {
+	if c.OldPath != "" {
+		return c.OldPath
+	}
+	return filepath.Join(filepath.Dir(c.Path), "old."+filepath.Base(c.Path))
+}
+
+// FileMode returns the permissions the file is written with
+func (c *ConfigFile) FileMode() os.FileMode {
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil {
+		mode, _ = strconv.ParseUint(DefaultFileMode, 8, 32)
+	}
+	return os.FileMode(mode)
+}
+
+// TextLogs files are served line by line as they are, rather than parsed into entries
+const TextLogs = "text"
+
+// LogSource describes where an application's logs can be read from
+type LogSource struct {
+	Unit string `yaml:"unit" required:"false"`
+	// Files is a glob of the log files the application writes itself, besides the journal
+	Files string `yaml:"files" required:"false"`
+	// FileFormat is how the files are written: csvlog, stderr or text
+	FileFormat string `yaml:"file_format" required:"false"`
+	// LinePrefix is the log_line_prefix of stderr log files
+	LinePrefix string `yaml:"line_prefix" required:"false"`
+}
+
+// Application describes everything the admin API needs to know to manage a service
+type Application struct {
+	Name      string      `yaml:"-"`
+	Disabled  bool        `yaml:"disabled" required:"false"`
+	Aliases   []string    `yaml:"aliases" required:"false"`
+	Config    *ConfigFile `yaml:"config" required:"false"`
+	Validator string      `yaml:"validator" required:"false"`
+	// Format enables key-level edits of the config file: env, ini or postgresql
+	Format string `yaml:"format" required:"false"`
+	// Redact masks the secrets of the config file when it is read
+	Redact         *config_redaction.Rules `yaml:"redact" required:"false"`
+	Unit           string                  `yaml:"unit" required:"false"`
+	Reload         ReloadStrategy          `yaml:"reload" required:"false"`
+	HealthEndpoint string                  `yaml:"health_endpoint" required:"false"`
+	// Async applications can't be waited on after a lifecycle command, e.g. the admin API itself
+	Async bool       `yaml:"async" required:"false"`
+	Logs  *LogSource `yaml:"logs" required:"false"`
+	// After lists the applications that have to be restarted before this one when several are
+	// restarted together
+	After []string `yaml:"after" required:"false"`
+}
+
+// Capability checks whether an application supports a class of endpoints
+type Capability func(app *Application) bool
+
+func HasConfig(app *Application) bool {
+	return app.Config != nil && app.Config.Path != ""
+}
+
+func HasUnit(app *Application) bool {
+	return app.Unit != ""
+}
+
+func HasLogs(app *Application) bool {
+	return app.Logs != nil
+}
+
+// HasJournal applications log to the journal of a unit
+func HasJournal(app *Application) bool {
+	return app.Logs != nil && app.Logs.Unit != ""
+}
+
+// HasLogFiles applications write server logs to files that can be parsed into structured entries
+func HasLogFiles(app *Application) bool {
+	return app.Logs != nil && app.Logs.Files != "" && app.Logs.FileFormat != TextLogs
+}
+
+// HasTextLogFiles applications write log files that are only read line by line
+func HasTextLogFiles(app *Application) bool {
+	return app.Logs != nil && app.Logs.Files != "" && app.Logs.FileFormat == TextLogs
+}
+
+// Restartable reports whether a config change can be followed by a restart or reload of the unit
+func (app *Application) Restartable() bool {
+	return HasUnit(app) && app.Reload != None
+}
+
+// Registry is the set of applications managed by the admin API
+type Registry struct {
+	applications map[string]*Application
+	aliases      map[string]string
+	// order is the position of every application in the restart order
+	order map[string]int
+}
+
+// New builds a registry from the built-in defaults, merging in the applications configured in
+// adminapi.yaml; configured fields take precedence, and `disabled: true` removes an application
+func New(defaults map[string]*Application, overrides map[string]Application) (*Registry, error) {
+	applications := make(map[string]*Application)
+	for name, app := range defaults {
+		app.Name = name
+		applications[name] = app
+	}
+	for name, override := range overrides {
+		override := override
+		if override.Disabled {
+			delete(applications, name)
+			continue
+		}
+		if existing, ok := applications[name]; ok {
+			merge(existing, &override)
+			continue
+		}
+		override.Name = name
+		applications[name] = &override
+	}
+
+	registry := &Registry{applications: applications, aliases: make(map[string]string), order: make(map[string]int)}
+	for name, app := range applications {
+		if err := validate(app); err != nil {
+			return nil, fmt.Errorf("invalid application %s: %+v", name, err)
+		}
+		for _, alias := range app.Aliases {
+			registry.aliases[alias] = name
+		}
+	}
+	order, err := restartOrder(applications)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range order {
+		registry.order[name] = i
+	}
+	return registry, nil
+}
+
+func merge(into *Application, from *Application) {
+	if from.Config != nil {
+		if into.Config == nil {
+			into.Config = &ConfigFile{}
+		}
+		if from.Config.Path != "" {
+			into.Config.Path = from.Config.Path
+		}
+		if from.Config.OldPath != "" {
+			into.Config.OldPath = from.Config.OldPath
+		}
+		if from.Config.Mode != "" {
+			into.Config.Mode = from.Config.Mode
+		}
+		if from.Config.Owner != "" {
+			into.Config.Owner = from.Config.Owner
+		}
+		if from.Config.Group != "" {
+			into.Config.Group = from.Config.Group
+		}
+	}
+	if len(from.Aliases) > 0 {
+		into.Aliases = from.Aliases
+	}
+	if from.Validator != "" {
+		into.Validator = from.Validator
+	}
+	if from.Format != "" {
+		into.Format = from.Format
+	}
+	if from.Redact != nil {
+		into.Redact = from.Redact
+	}
+	if from.Unit != "" {
+		into.Unit = from.Unit
+	}
+	if from.Reload != "" {
+		into.Reload = from.Reload
+	}
+	if from.HealthEndpoint != "" {
+		into.HealthEndpoint = from.HealthEndpoint
+	}
+	if from.Async {
+		into.Async = true
+	}
+	if from.Logs != nil {
+		into.Logs = from.Logs
+	}
+	if len(from.After) > 0 {
+		into.After = from.After
+	}
+}
+
+func validate(app *Application) error {
+	switch app.Reload {
+	case "":
+		app.Reload = Restart
+	case Restart, Reload, None:
+	default:
+		return fmt.Errorf("unknown reload strategy %q", app.Reload)
+	}
+	if app.Validator != "" {
+		if _, ok := config_validation.Get(app.Validator); !ok {
+			return fmt.Errorf("unknown validator %q", app.Validator)
+		}
+	}
+	if app.Format != "" && !config_edit.IsSupported(app.Format) {
+		return fmt.Errorf("unknown config format %q", app.Format)
+	}
+	if app.Redact != nil {
+		if err := app.Redact.Compile(); err != nil {
+			return err
+		}
+		if len(app.Redact.Keys) > 0 && app.Format == "" {
+			return fmt.Errorf("redacting keys requires a config format")
+		}
+	}
+	if app.Logs != nil && app.Logs.Files != "" {
+		if app.Logs.FileFormat == "" {
+			app.Logs.FileFormat = postgres_logs.CSVLog
+		}
+		if app.Logs.FileFormat != TextLogs && !postgres_logs.IsSupported(app.Logs.FileFormat) {
+			return fmt.Errorf("unknown log file format %q", app.Logs.FileFormat)
+		}
+		if app.Logs.LinePrefix != "" {
+			if err := postgres_logs.ValidateLinePrefix(app.Logs.LinePrefix); err != nil {
+				return err
+			}
+		}
+	}
+	if app.Config != nil {
+		if app.Config.Mode == "" {
+			app.Config.Mode = DefaultFileMode
+		}
+		if _, err := strconv.ParseUint(app.Config.Mode, 8, 32); err != nil {
+			return fmt.Errorf("invalid file mode %q", app.Config.Mode)
+		}
+	}
+	return nil
+}
+
+// Get looks up an application by name or alias
+func (r *Registry) Get(name string) (*Application, bool) {
+	if canonical, ok := r.aliases[name]; ok {
+		name = canonical
+	}
+	app, ok := r.applications[name]
+	return app, ok
+}
+
+// Names returns the names of every registered application, sorted
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.applications))
+	for name := range r.applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// All returns every registered application that has the given capability, sorted by name
+func (r *Registry) All(capability Capability) []*Application {
+	apps := make([]*Application, 0)
+	for _, name := range r.Names() {
+		if app := r.applications[name]; capability(app) {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
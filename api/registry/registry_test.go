@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryMergesConfiguredApplications(t *testing.T) {
+	defaults := Defaults(DefaultsConfig{RealtimeServiceName: "supabase"})
+	registry, err := New(defaults, map[string]Application{
+		"gotrue": {
+			Config: &ConfigFile{Mode: "0640", Owner: "gotrue"},
+		},
+		"pglisten": {
+			Disabled: true,
+		},
+		"storage-api": {
+			Config: &ConfigFile{Path: "/etc/storage/storage.env"},
+			Unit:   "storage.service",
+			Logs:   &LogSource{Unit: "storage.service"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotrue, ok := registry.Get("gotrue")
+	if !ok || gotrue.Config.Path != "/etc/gotrue.env" || gotrue.Config.FileMode() != 0640 || gotrue.Config.Owner != "gotrue" || gotrue.Unit != "gotrue.service" {
+		t.Fatalf("expected gotrue defaults to be merged with overrides, got %+v %+v", gotrue, gotrue.Config)
+	}
+	if _, ok := registry.Get("pglisten"); ok {
+		t.Fatal("expected pglisten to be disabled")
+	}
+	storage, ok := registry.Get("storage-api")
+	if !ok || storage.Reload != Restart || storage.Config.BackupPath() != "/etc/storage/old.storage.env" || storage.Config.FileMode() != 0664 {
+		t.Fatalf("expected storage-api to be registered with defaults filled in, got %+v", storage)
+	}
+	if admin, ok := registry.Get("admin"); !ok || admin.Name != "adminapi" {
+		t.Fatalf("expected admin to be an alias of adminapi, got %+v", admin)
+	}
+	if realtime, _ := registry.Get("realtime"); realtime.Unit != "supabase.service" {
+		t.Fatalf("expected realtime unit to follow the configured service name, got %s", realtime.Unit)
+	}
+}
+
+func TestRegistryRejectsInvalidApplications(t *testing.T) {
+	if _, err := New(nil, map[string]Application{"imgproxy": {Unit: "imgproxy.service", Reload: "bounce"}}); err == nil {
+		t.Fatal("expected unknown reload strategy to be rejected")
+	}
+	if _, err := New(nil, map[string]Application{"imgproxy": {Validator: "xml"}}); err == nil {
+		t.Fatal("expected unknown validator to be rejected")
+	}
+}
+
+func TestRegistryRestartOrder(t *testing.T) {
+	registry, err := New(Defaults(DefaultsConfig{RealtimeServiceName: "supabase"}), map[string]Application{
+		"pgbouncer":   {Disabled: true},
+		"storage-api": {Unit: "storage.service", After: []string{"postgresql"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := make([]*Application, 0)
+	for _, name := range []string{"adminapi", "kong", "storage-api", "gotrue", "postgresql"} {
+		app, _ := registry.Get(name)
+		apps = append(apps, app)
+	}
+	names := make([]string, 0)
+	for _, app := range registry.InRestartOrder(apps) {
+		names = append(names, app.Name)
+	}
+	if strings.Join(names, ",") != "postgresql,gotrue,kong,storage-api,adminapi" {
+		t.Fatalf("unexpected restart order %v", names)
+	}
+
+	if _, err := New(nil, map[string]Application{"a": {After: []string{"b"}}, "b": {After: []string{"a"}}}); err == nil {
+		t.Fatal("expected a cyclic restart order to be rejected")
+	}
+}
@@ -0,0 +1,196 @@
+package restart_limits
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultMaxRestarts = 5
+const DefaultWindow = "10m"
+const DefaultBreakerThreshold = 3
+
+// Config bounds how often units may be restarted; a negative max_restarts or breaker_threshold
+// turns the respective protection off
+type Config struct {
+	MaxRestarts      int    `yaml:"max_restarts" required:"false"`
+	Window           string `yaml:"window" required:"false"`
+	BreakerThreshold int    `yaml:"breaker_threshold" required:"false"`
+}
+
+// BudgetExhaustedError is returned when a unit has used up its restart budget
+type BudgetExhaustedError struct {
+	Unit        string
+	MaxRestarts int
+	Window      time.Duration
+	RetryAfter  time.Duration
+}
+
+func (e *BudgetExhaustedError) Error() string {
+	return fmt.Sprintf("%s has already been restarted %d times in the last %s, retry in %s", e.Unit, e.MaxRestarts, e.Window, e.RetryAfter)
+}
+
+// BreakerOpenError is returned when a unit's last restarts all failed, until the breaker is reset
+type BreakerOpenError struct {
+	Unit     string
+	Failures int
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("the last %d restarts of %s failed, refusing to restart it again until its circuit breaker is reset", e.Failures, e.Unit)
+}
+
+// UnitState is how much of its restart budget a unit has used, and whether its breaker is open
+type UnitState struct {
+	Unit                string     `json:"unit"`
+	Restarts            int        `json:"restarts"`
+	MaxRestarts         int        `json:"max_restarts"`
+	Window              string     `json:"window"`
+	RetryAfter          *time.Time `json:"retry_after,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	BreakerOpen         bool       `json:"breaker_open"`
+}
+
+type unitHistory struct {
+	restarts            []time.Time
+	consecutiveFailures int
+}
+
+// Limiter keeps track of restarts per unit, in memory
+type Limiter struct {
+	maxRestarts      int
+	window           time.Duration
+	breakerThreshold int
+	now              func() time.Time
+
+	mu    sync.Mutex
+	units map[string]*unitHistory
+}
+
+func NewLimiter(config Config) (*Limiter, error) {
+	if config.MaxRestarts == 0 {
+		config.MaxRestarts = DefaultMaxRestarts
+	}
+	if config.Window == "" {
+		config.Window = DefaultWindow
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = DefaultBreakerThreshold
+	}
+	window, err := time.ParseDuration(config.Window)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse restart limits window")
+	}
+	return &Limiter{
+		maxRestarts:      config.MaxRestarts,
+		window:           window,
+		breakerThreshold: config.BreakerThreshold,
+		now:              time.Now,
+		units:            make(map[string]*unitHistory),
+	}, nil
+}
+
+func (l *Limiter) history(unit string) *unitHistory {
+	history, ok := l.units[unit]
+	if !ok {
+		history = &unitHistory{}
+		l.units[unit] = history
+	}
+	// restarts that have left the window no longer count against the budget
+	cutoff := l.now().Add(-l.window)
+	for len(history.restarts) > 0 && !history.restarts[0].After(cutoff) {
+		history.restarts = history.restarts[1:]
+	}
+	return history
+}
+
+// Take uses up one restart of a unit's budget, failing with a *BudgetExhaustedError or a
+// *BreakerOpenError if the unit may not be restarted right now
+func (l *Limiter) Take(unit string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	history := l.history(unit)
+	if err := l.check(unit, history); err != nil {
+		return err
+	}
+	history.restarts = append(history.restarts, l.now())
+	return nil
+}
+
+// Check fails as Take would, without using up any of the unit's budget
+func (l *Limiter) Check(unit string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.check(unit, l.history(unit))
+}
+
+func (l *Limiter) check(unit string, history *unitHistory) error {
+	if l.breakerThreshold > 0 && history.consecutiveFailures >= l.breakerThreshold {
+		return &BreakerOpenError{Unit: unit, Failures: history.consecutiveFailures}
+	}
+	if l.maxRestarts > 0 && len(history.restarts) >= l.maxRestarts {
+		return &BudgetExhaustedError{
+			Unit:        unit,
+			MaxRestarts: l.maxRestarts,
+			Window:      l.window,
+			RetryAfter:  history.restarts[0].Add(l.window).Sub(l.now()),
+		}
+	}
+	return nil
+}
+
+// Record notes how a restart of a unit went, opening its breaker after too many failures in a row
+func (l *Limiter) Record(unit string, succeeded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	history := l.history(unit)
+	if succeeded {
+		history.consecutiveFailures = 0
+	} else {
+		history.consecutiveFailures++
+	}
+}
+
+// Reset closes a unit's breaker
+func (l *Limiter) Reset(unit string) UnitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.history(unit).consecutiveFailures = 0
+	return l.state(unit)
+}
+
+// States returns the state of every unit restarted so far, by unit name
+func (l *Limiter) States() []UnitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.units))
+	for unit := range l.units {
+		names = append(names, unit)
+	}
+	sort.Strings(names)
+	states := make([]UnitState, 0, len(names))
+	for _, unit := range names {
+		states = append(states, l.state(unit))
+	}
+	return states
+}
+
+func (l *Limiter) state(unit string) UnitState {
+	history := l.history(unit)
+	state := UnitState{
+		Unit:                unit,
+		Restarts:            len(history.restarts),
+		MaxRestarts:         l.maxRestarts,
+		Window:              l.window.String(),
+		ConsecutiveFailures: history.consecutiveFailures,
+		BreakerOpen:         l.breakerThreshold > 0 && history.consecutiveFailures >= l.breakerThreshold,
+	}
+	if l.maxRestarts > 0 && len(history.restarts) >= l.maxRestarts {
+		retryAfter := history.restarts[0].Add(l.window)
+		state.RetryAfter = &retryAfter
+	}
+	return state
+}
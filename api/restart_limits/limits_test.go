@@ -0,0 +1,64 @@
+package restart_limits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBudget(t *testing.T) {
+	limiter, err := NewLimiter(Config{MaxRestarts: 2, Window: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Take("kong.service"); err != nil {
+			t.Fatalf("expected restart %d to be allowed, got %v", i, err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if _, ok := limiter.Check("kong.service").(*BudgetExhaustedError); !ok {
+		t.Fatal("expected checking the budget to report it used up")
+	}
+	err = limiter.Take("kong.service")
+	if exhausted, ok := err.(*BudgetExhaustedError); !ok || exhausted.RetryAfter != 40*time.Second {
+		t.Fatalf("expected the budget to be used up for another 40s, got %v", err)
+	}
+	if err := limiter.Take("gotrue.service"); err != nil {
+		t.Fatalf("expected budgets to be per unit, got %v", err)
+	}
+	if err := limiter.Check("gotrue.service"); err != nil || len(limiter.units["gotrue.service"].restarts) != 1 {
+		t.Fatalf("expected checking the budget not to use it up, got %v", err)
+	}
+
+	now = now.Add(40 * time.Second)
+	if err := limiter.Take("kong.service"); err != nil {
+		t.Fatalf("expected the oldest restart to have left the window, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	limiter, _ := NewLimiter(Config{MaxRestarts: -1, BreakerThreshold: 2})
+
+	limiter.Record("kong.service", false)
+	limiter.Record("kong.service", true)
+	limiter.Record("kong.service", false)
+	if err := limiter.Take("kong.service"); err != nil {
+		t.Fatalf("expected a success to reset the failure count, got %v", err)
+	}
+	limiter.Record("kong.service", false)
+	if _, ok := limiter.Take("kong.service").(*BreakerOpenError); !ok {
+		t.Fatal("expected the breaker to open after two failures in a row")
+	}
+	if states := limiter.States(); len(states) != 1 || !states[0].BreakerOpen || states[0].ConsecutiveFailures != 2 {
+		t.Fatalf("expected the breaker to be reported open, got %+v", states)
+	}
+	if state := limiter.Reset("kong.service"); state.BreakerOpen {
+		t.Fatalf("expected the reset to close the breaker, got %+v", state)
+	}
+	if err := limiter.Take("kong.service"); err != nil {
+		t.Fatalf("expected restarts to be allowed after a reset, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -16,6 +17,22 @@ const (
 	Service       Role = "service_role"
 )
 
+type contextKey string
+
+const claimsContextKey = contextKey("claims")
+
+// getClaims returns the claims of the JWT that authenticated the request, if any
+func getClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(jwt.MapClaims)
+	return claims
+}
+
+// getSubject returns the subject of the JWT that authenticated the request, if any
+func getSubject(r *http.Request) string {
+	sub, _ := getClaims(r)["sub"].(string)
+	return sub
+}
+
 func (h ErrorHandlingWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := h(w, r); err != nil {
 		handleError(err, w, r)
@@ -80,7 +97,7 @@ func tokenVerifier(w http.ResponseWriter, r *http.Request, tokenString string, a
 		role, ok := claims["role"]
 		if ok && role == roleName {
 			// successful authentication
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 			return
 		} else {
 			if err := sendJSON(
@@ -1,13 +1,17 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"github.com/supabase/supabase-admin-api/api/units"
 )
 
 type LifecycleCommand = string
@@ -18,6 +22,7 @@ const (
 	Stop    LifecycleCommand = "stop"
 	Start   LifecycleCommand = "start"
 	Restart LifecycleCommand = "restart"
+	Reload  LifecycleCommand = "reload"
 	Enable  LifecycleCommand = "enable"
 	Disable LifecycleCommand = "disable"
 )
@@ -31,7 +36,13 @@ func getLifecycleCommand(r *http.Request) (LifecycleCommand, error) {
 	if len(vals) > 1 {
 		return Restart, fmt.Errorf("only a single lifecycle command was expected: %+v", vals)
 	}
-	switch vals[0] {
+	return parseLifecycleCommand(vals[0])
+}
+
+func parseLifecycleCommand(command string) (LifecycleCommand, error) {
+	switch command {
+	case Restart:
+		return Restart, nil
 	case Start:
 		return Start, nil
 	case Stop:
@@ -40,89 +51,112 @@ func getLifecycleCommand(r *http.Request) (LifecycleCommand, error) {
 		return Enable, nil
 	case Disable:
 		return Disable, nil
+	case Reload:
+		return Reload, nil
 	default:
-		return Restart, fmt.Errorf("unknown lifecycle command: %+v", vals[0])
+		return Restart, fmt.Errorf("unknown lifecycle command: %+v", command)
 	}
 }
 
 // HandleLifecycleCommand is the endpoint for executing service lifecycle commands
 func (a *API) HandleLifecycleCommand(w http.ResponseWriter, r *http.Request) error {
-	sudo := "sudo"
-	app := "systemctl"
-	arg0 := "daemon-reload"
-
-	cmd := exec.Command(sudo, app, arg0)
-	stdout, err := cmd.Output()
-
-	if err != nil {
-		fmt.Fprint(os.Stderr, err.Error())
-		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	application := chi.URLParam(r, "application")
+	unit, async, ok := a.lifecycleTarget(application)
+	if !ok {
+		return sendJSON(w, http.StatusNotFound, fmt.Sprintf("unknown application %q", application))
 	}
 
-	fmt.Fprint(os.Stdout, string(stdout))
-
 	lifecycleCommand, err := getLifecycleCommand(r)
 	if err != nil {
 		fmt.Fprint(os.Stderr, err.Error())
 		return sendJSON(w, http.StatusBadRequest, err.Error())
 	}
 
-	// need to do command as goroutine because adminapi gets killed and can't respond
-	go func() {
-		sudo := "sudo"
-		app := "systemctl"
-		var arg1 string
-
-		application := chi.URLParam(r, "application")
-
-		switch application {
-		case "all":
-			arg1 = "services.slice"
-		case "gotrue":
-			arg1 = "gotrue.service"
-		case "postgrest":
-			arg1 = "postgrest.service"
-		case "pglisten":
-			arg1 = "pglisten.service"
-		case "kong":
-			arg1 = "kong.service"
-		case "realtime":
-			arg1 = fmt.Sprintf("%s.service", a.config.RealtimeServiceName)
-		case "adminapi":
-			arg1 = "adminapi.service"
-		case "pgsodium":
-			arg1 = derivePostgresqlUnitName()
-		case "postgresql":
-			arg1 = derivePostgresqlUnitName()
-		case "pgbouncer":
-			arg1 = "pgbouncer.service"
-		default:
-			arg1 = "services.slice"
-		}
-
-		// if admin api is getting restarted give time for http response first
-		if application == "adminapi" || application == "all" {
-			time.Sleep(2 * time.Second)
-		}
+	var job *jobs.Job
+	if unit == registry.SysService && lifecycleCommand == Restart {
+		// restarting the whole slice at once brings services up before the ones they depend on
+		job, err = a.startStackRestartJob(getSubject(r))
+	} else {
+		job, err = a.startLifecycleJob(lifecycleCommand, unit, async, getSubject(r))
+	}
+	if err != nil {
+		return sendRestartError(w, err)
+	}
 
-		cmd = exec.Command(sudo, app, lifecycleCommand, arg1)
-		stdout, err = cmd.Output()
+	return sendJSON(w, http.StatusAccepted, job)
+}
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to %s %s service: %+v\n", lifecycleCommand, arg1, err)
+// startLifecycleJob reloads the systemd configuration and then runs the lifecycle command as a job;
+// a restart is first taken from the unit's restart budget
+func (a *API) startLifecycleJob(lifecycleCommand LifecycleCommand, unit string, async bool, author string) (*jobs.Job, error) {
+	if lifecycleCommand == Restart {
+		if err := a.takeRestart(unit); err != nil {
+			return nil, err
 		}
+	}
+	return a.submitLifecycleJob(lifecycleCommand, unit, async, author)
+}
 
-		fmt.Fprint(os.Stdout, string(stdout))
-	}()
+// submitLifecycleJob runs a lifecycle command as a job without going through the restart budget,
+// for restarts putting back a previous config
+func (a *API) submitLifecycleJob(lifecycleCommand LifecycleCommand, unit string, async bool, author string) (*jobs.Job, error) {
+	spec := jobs.Spec{
+		Kind:             "lifecycle",
+		Description:      fmt.Sprintf("%s %s", lifecycleCommand, unit),
+		Author:           author,
+		RestartsAdminAPI: async,
+		Run: func(ctx context.Context, output io.Writer) error {
+			ctx, cancel := context.WithTimeout(ctx, a.applyTimeout)
+			defer cancel()
+			if err := a.units.DaemonReload(ctx); err != nil {
+				return err
+			}
+			err := runLifecycleCommand(ctx, a.units, lifecycleCommand, unit)
+			if lifecycleCommand == Restart && ctx.Err() != context.Canceled {
+				a.restartLimits.Record(unit, err == nil)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "%s %s: done\n", lifecycleCommand, unit)
+			return nil
+		},
+	}
+	if async {
+		// the admin API gets killed along with the unit, so it has to get its response out first
+		spec.Delay = 2 * time.Second
+	}
+	return a.jobs.Submit(spec)
+}
 
-	return sendJSON(w, http.StatusOK, 200)
+// runLifecycleCommand runs a lifecycle command against a unit, waiting for systemd to carry it out
+func runLifecycleCommand(ctx context.Context, manager units.UnitManager, lifecycleCommand LifecycleCommand, unit string) error {
+	switch lifecycleCommand {
+	case Start:
+		return manager.Start(ctx, unit)
+	case Stop:
+		return manager.Stop(ctx, unit)
+	case Restart:
+		return manager.Restart(ctx, unit)
+	case Reload:
+		return manager.Reload(ctx, unit)
+	case Enable:
+		return manager.Enable(ctx, unit)
+	case Disable:
+		return manager.Disable(ctx, unit)
+	}
+	return fmt.Errorf("unknown lifecycle command: %s", lifecycleCommand)
 }
 
-func derivePostgresqlUnitName() string {
-	_, err := os.Stat("/etc/postgresql/postgresql.conf")
-	if err != nil {
-		return "postgresql@12-main.service"
-	} else {
-		return "postgresql.service"
+// lifecycleTarget resolves the unit a lifecycle command applies to, and whether the admin API may
+// be taken down along with it; "all" (or no application at all) targets the whole services slice
+func (a *API) lifecycleTarget(application string) (string, bool, bool) {
+	if application == "" || application == "all" {
+		return registry.SysService, true, true
+	}
+	app, ok := a.applications.Get(application)
+	if !ok || !registry.HasUnit(app) {
+		return "", false, false
 	}
+	return app.Unit, app.Async, true
 }
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/supabase/supabase-admin-api/api/restart_limits"
+)
+
+// takeRestart uses up one restart of a unit's budget; every restart goes through it, and fails
+// with a *restart_limits.BudgetExhaustedError or a *restart_limits.BreakerOpenError when the unit
+// may not be restarted right now
+func (a *API) takeRestart(unit string) error {
+	return a.restartLimits.Take(unit)
+}
+
+// checkRestart fails as takeRestart would without using up the budget, so that requests ending in
+// a restart can be refused before they change anything
+func (a *API) checkRestart(unit string, command LifecycleCommand) error {
+	if command != Restart {
+		return nil
+	}
+	return a.restartLimits.Check(unit)
+}
+
+// isRestartRefused reports whether an error is a restart refused by the budget or the breaker
+func isRestartRefused(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *restart_limits.BudgetExhaustedError, *restart_limits.BreakerOpenError:
+		return true
+	}
+	return false
+}
+
+// sendRestartError answers with a 429 once a unit's restart budget is used up, a 409 while its
+// circuit breaker is open, and a 500 for any other error
+func sendRestartError(w http.ResponseWriter, err error) error {
+	switch cause := errors.Cause(err).(type) {
+	case *restart_limits.BudgetExhaustedError:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cause.RetryAfter.Seconds()))))
+		return sendJSON(w, http.StatusTooManyRequests, err.Error())
+	case *restart_limits.BreakerOpenError:
+		return sendJSON(w, http.StatusConflict, err.Error())
+	default:
+		return sendJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// GetRestartLimits returns the restart budget and circuit breaker state of every unit restarted
+// since the admin API started
+func (a *API) GetRestartLimits(w http.ResponseWriter, r *http.Request) error {
+	return sendJSON(w, http.StatusOK, a.restartLimits.States())
+}
+
+// ResetRestartBreaker closes the circuit breaker of an application's unit, allowing it to be
+// restarted again
+func (a *API) ResetRestartBreaker(w http.ResponseWriter, r *http.Request) error {
+	application := chi.URLParam(r, "application")
+	unit, _, ok := a.lifecycleTarget(application)
+	if !ok {
+		return sendJSON(w, http.StatusNotFound, fmt.Sprintf("unknown application %q", application))
+	}
+	return sendJSON(w, http.StatusOK, a.restartLimits.Reset(unit))
+}
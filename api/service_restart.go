@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/registry"
+)
+
+func (step ApplyStep) String() string {
+	if !step.Success {
+		return fmt.Sprintf("%s %s: failed after %s: %s", step.Action, step.Target, step.Duration, step.Error)
+	}
+	return fmt.Sprintf("%s %s: ok after %s", step.Action, step.Target, step.Duration)
+}
+
+// startStackRestartJob restarts every managed service one at a time, in restart order, waiting for
+// each to come back active and healthy before moving on to the next; the admin API goes last. The
+// stack restart takes from the budget of the services slice, and each unit from its own, all of
+// which are checked before anything is restarted. Cancelling the job stops it between services
+func (a *API) startStackRestartJob(author string) (*jobs.Job, error) {
+	plan := restartPlan(a.applications.InRestartOrder(a.applications.All(registry.HasUnit)))
+	for _, app := range plan {
+		if err := a.checkConfigRestart(app); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.takeRestart(registry.SysService); err != nil {
+		return nil, err
+	}
+	return a.jobs.Submit(jobs.Spec{
+		Kind:        "lifecycle",
+		Description: "restart all services",
+		Author:      author,
+		Run: func(ctx context.Context, output io.Writer) error {
+			steps := make(ApplySteps, 0)
+			failed := a.runRestartPlan(ctx, &steps, plan, author, false)
+			for _, step := range steps {
+				fmt.Fprintln(output, step)
+			}
+			if failed != -1 && ctx.Err() != nil {
+				return errors.Wrapf(ctx.Err(), "stopped at %s", plan[failed].Name)
+			}
+			a.restartLimits.Record(registry.SysService, failed == -1)
+			if failed == -1 {
+				return nil
+			}
+			remaining := make([]string, 0)
+			for _, app := range plan[failed+1:] {
+				remaining = append(remaining, app.Name)
+			}
+			if len(remaining) == 0 {
+				return fmt.Errorf("%s didn't come back", plan[failed].Name)
+			}
+			return fmt.Errorf("%s didn't come back, stopped before restarting %s", plan[failed].Name, strings.Join(remaining, ", "))
+		},
+	})
+}
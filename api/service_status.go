@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"github.com/supabase/supabase-admin-api/api/units"
+)
+
+const serviceStatusTimeout = 10 * time.Second
+
+// ServiceStatus is the state of the unit of a managed application
+type ServiceStatus struct {
+	Application string `json:"application"`
+	*units.Status
+	Error string `json:"error,omitempty"`
+}
+
+func (a *API) serviceStatus(ctx context.Context, app *registry.Application) *ServiceStatus {
+	status, err := a.units.Status(ctx, app.Unit)
+	if err != nil {
+		return &ServiceStatus{Application: app.Name, Status: &units.Status{Unit: app.Unit}, Error: err.Error()}
+	}
+	return &ServiceStatus{Application: app.Name, Status: status}
+}
+
+// GetServiceStatuses returns the state of the unit of every managed application
+func (a *API) GetServiceStatuses(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), serviceStatusTimeout)
+	defer cancel()
+
+	statuses := make([]*ServiceStatus, 0)
+	for _, app := range a.applications.All(registry.HasUnit) {
+		statuses = append(statuses, a.serviceStatus(ctx, app))
+	}
+	return sendJSON(w, http.StatusOK, statuses)
+}
+
+// GetServiceStatus returns the state of the unit of a single application
+func (a *API) GetServiceStatus(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), serviceStatusTimeout)
+	defer cancel()
+
+	status := a.serviceStatus(ctx, getApplication(r))
+	if status.Error != "" {
+		return sendJSON(w, http.StatusInternalServerError, status)
+	}
+	return sendJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supabase/supabase-admin-api/api/jobs"
+	"github.com/supabase/supabase-admin-api/api/registry"
+	"github.com/supabase/supabase-admin-api/api/restart_limits"
+	"github.com/supabase/supabase-admin-api/api/units"
+)
+
+func lifecycleTestServer(t *testing.T, unitNames ...string) (*httptest.Server, *API, *units.Fake) {
+	dir := t.TempDir()
+	api := NewAPIWithVersion(&Config{
+		JwtSecret:                      "awdawdawdawdawdaw",
+		UpstreamMetricsRefreshDuration: "60s",
+		RealtimeServiceName:            "realtime",
+		ConfigHistoryDir:               filepath.Join(dir, "history"),
+		JobsDir:                        filepath.Join(dir, "jobs"),
+		MaintenanceDir:                 filepath.Join(dir, "maintenance"),
+		// the admin API restarts itself in a delayed job of its own, which would outlive the test
+		Applications: map[string]registry.Application{"adminapi": {Disabled: true}},
+	}, "0.0")
+	fake := units.NewFake(unitNames...)
+	api.units = fake
+	api.unitSettlePeriod = 20 * time.Millisecond
+	return httptest.NewServer(api.handler), api, fake
+}
+
+func lifecycleJob(t *testing.T, api *API, body string) *jobs.Job {
+	submitted := &jobs.Job{}
+	if err := json.Unmarshal([]byte(body), submitted); err != nil {
+		t.Fatalf("expected a job, got %s", body)
+	}
+	return waitForJob(t, api, submitted.ID)
+}
+
+func waitForJob(t *testing.T, api *API, id string) *jobs.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := api.jobs.Wait(ctx, id)
+	if err != nil || !job.Finished() {
+		t.Fatalf("expected the job to finish, got %+v %v", job, err)
+	}
+	return job
+}
+
+func TestLifecycleCommands(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "kong.service", "gotrue.service")
+	defer ts.Close()
+
+	response, body := configRequest(t, ts, "GET", "/service/restart/kong", "", nil)
+	if response.StatusCode != 202 {
+		t.Fatalf("expected the restart to be accepted, got %d %s", response.StatusCode, body)
+	}
+	if job := lifecycleJob(t, api, body); job.State != jobs.Succeeded {
+		t.Fatalf("expected the restart to succeed, got %+v", job)
+	}
+	if status, _ := fake.Status(context.Background(), "kong.service"); status.ActiveState != "active" {
+		t.Fatalf("expected kong to be running, got %+v", status)
+	}
+
+	response, body = configRequest(t, ts, "GET", "/service/restart/gotrue", "", map[string]string{LifecycleCommandHeader: Enable})
+	if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Succeeded || !fake.Enabled("gotrue.service") {
+		t.Fatalf("expected gotrue to be enabled, got %d %+v", response.StatusCode, job)
+	}
+
+	expected := []string{"daemon-reload", "restart kong.service", "status kong.service", "daemon-reload", "enable gotrue.service"}
+	if calls := fake.CallLog(); !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+
+	fake.Failures["gotrue.service"] = &units.JobFailedError{Unit: "gotrue.service", Operation: "start", Result: "failed"}
+	response, body = configRequest(t, ts, "GET", "/service/restart/gotrue", "", map[string]string{LifecycleCommandHeader: Start})
+	if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Failed || job.Error != "start gotrue.service failed: failed" {
+		t.Fatalf("expected the start to fail, got %d %+v", response.StatusCode, job)
+	}
+
+	// postgrest is a registered application, but its unit doesn't exist
+	response, body = configRequest(t, ts, "GET", "/service/restart/postgrest", "", nil)
+	if job := lifecycleJob(t, api, body); job.State != jobs.Failed || job.Error != "restart postgrest.service: unit not found" {
+		t.Fatalf("expected the restart of a missing unit to fail, got %d %+v", response.StatusCode, job)
+	}
+
+	if response, _ := configRequest(t, ts, "GET", "/service/restart/nope", "", nil); response.StatusCode != 404 {
+		t.Fatalf("expected an unknown application to be rejected, got %d", response.StatusCode)
+	}
+	if response, _ := configRequest(t, ts, "GET", "/service/restart/kong", "", map[string]string{LifecycleCommandHeader: "explode"}); response.StatusCode != 400 {
+		t.Fatalf("expected an unknown lifecycle command to be rejected, got %d", response.StatusCode)
+	}
+
+	response, body = configRequest(t, ts, "GET", "/service/status/kong", "", nil)
+	status := &ServiceStatus{}
+	if err := json.Unmarshal([]byte(body), status); err != nil || response.StatusCode != 200 || status.Application != "kong" || status.ActiveState != "active" {
+		t.Fatalf("expected kong's status, got %d %s", response.StatusCode, body)
+	}
+}
+
+func restartedUnits(fake *units.Fake) []string {
+	restarted := make([]string, 0)
+	for _, call := range fake.CallLog() {
+		if strings.HasPrefix(call, "restart ") {
+			restarted = append(restarted, strings.TrimPrefix(call, "restart "))
+		}
+	}
+	return restarted
+}
+
+func TestStackRestart(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "postgresql@12-main.service", "pgbouncer.service", "gotrue.service", "pglisten.service", "postgrest.service", "realtime.service", "kong.service")
+	defer ts.Close()
+
+	response, body := configRequest(t, ts, "GET", "/service/restart/all", "", nil)
+	if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Succeeded {
+		t.Fatalf("expected the stack restart to succeed, got %d %+v", response.StatusCode, job)
+	}
+	expected := []string{"postgresql@12-main.service", "pgbouncer.service", "gotrue.service", "pglisten.service", "postgrest.service", "realtime.service", "kong.service"}
+	if restarted := restartedUnits(fake); !reflect.DeepEqual(restarted, expected) {
+		t.Fatalf("expected services to be restarted in order %v, got %v", expected, restarted)
+	}
+
+	fake = units.NewFake(expected...)
+	fake.Failures["postgrest.service"] = &units.JobFailedError{Unit: "postgrest.service", Operation: "restart", Result: "failed"}
+	api.units = fake
+	response, body = configRequest(t, ts, "GET", "/service/restart/all", "", nil)
+	job := lifecycleJob(t, api, body)
+	if job.State != jobs.Failed || job.Error != "postgrest didn't come back, stopped before restarting realtime, kong" {
+		t.Fatalf("expected the stack restart to stop at postgrest, got %+v", job)
+	}
+	if !strings.Contains(job.Output, "restart postgrest.service: failed") {
+		t.Fatalf("expected the failed step to be reported, got %s", job.Output)
+	}
+	if restarted := restartedUnits(fake); !reflect.DeepEqual(restarted, expected[:5]) {
+		t.Fatalf("expected the restart to stop at postgrest, got %v", restarted)
+	}
+
+	// every unit's budget is checked before the first restart
+	fake = units.NewFake(expected...)
+	api.units = fake
+	api.restartLimits, _ = restart_limits.NewLimiter(restart_limits.Config{MaxRestarts: 1, Window: "10m"})
+	if err := api.restartLimits.Take("kong.service"); err != nil {
+		t.Fatal(err)
+	}
+	if response, body := configRequest(t, ts, "GET", "/service/restart/all", "", nil); response.StatusCode != 429 {
+		t.Fatalf("expected kong's used up budget to refuse the stack restart, got %d %s", response.StatusCode, body)
+	}
+	if restarted := restartedUnits(fake); len(restarted) != 0 {
+		t.Fatalf("expected nothing to be restarted, got %v", restarted)
+	}
+
+	// cancelling the job stops it between services
+	api.restartLimits, _ = restart_limits.NewLimiter(restart_limits.Config{})
+	api.unitSettlePeriod = 200 * time.Millisecond
+	_, body = configRequest(t, ts, "GET", "/service/restart/all", "", nil)
+	submitted := &jobs.Job{}
+	if err := json.Unmarshal([]byte(body), submitted); err != nil {
+		t.Fatalf("expected a job, got %s", body)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(restartedUnits(fake)) == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected postgresql to be restarted")
+		}
+	}
+	if _, err := api.jobs.Cancel(submitted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, api, submitted.ID); job.State != jobs.Cancelled {
+		t.Fatalf("expected the stack restart to be cancelled, got %+v", job)
+	}
+	if restarted := restartedUnits(fake); !reflect.DeepEqual(restarted, expected[:1]) {
+		t.Fatalf("expected the restart to stop after postgresql, got %v", restarted)
+	}
+}
+
+func TestRestartLimits(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "kong.service", "gotrue.service")
+	defer ts.Close()
+	api.restartLimits, _ = restart_limits.NewLimiter(restart_limits.Config{MaxRestarts: 3, Window: "10m", BreakerThreshold: 2})
+
+	for i := 0; i < 3; i++ {
+		response, body := configRequest(t, ts, "GET", "/service/restart/kong", "", nil)
+		if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Succeeded {
+			t.Fatalf("expected restart %d to succeed, got %d %s", i, response.StatusCode, body)
+		}
+	}
+	response, _ := configRequest(t, ts, "GET", "/service/restart/kong", "", nil)
+	if response.StatusCode != 429 || response.Header.Get("Retry-After") != "600" {
+		t.Fatalf("expected the restart budget to be used up, got %d %q", response.StatusCode, response.Header.Get("Retry-After"))
+	}
+	response, body := configRequest(t, ts, "GET", "/service/restart/kong", "", map[string]string{LifecycleCommandHeader: Stop})
+	if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Succeeded {
+		t.Fatalf("expected other lifecycle commands not to count against the budget, got %d %s", response.StatusCode, body)
+	}
+
+	fake.Failures["gotrue.service"] = &units.JobFailedError{Unit: "gotrue.service", Operation: "restart", Result: "failed"}
+	for i := 0; i < 2; i++ {
+		_, body := configRequest(t, ts, "GET", "/service/restart/gotrue", "", nil)
+		if job := lifecycleJob(t, api, body); job.State != jobs.Failed {
+			t.Fatalf("expected restart %d to fail, got %+v", i, job)
+		}
+	}
+	if response, _ := configRequest(t, ts, "GET", "/service/restart/gotrue", "", nil); response.StatusCode != 409 {
+		t.Fatalf("expected the circuit breaker to refuse the restart, got %d", response.StatusCode)
+	}
+
+	// config writes ending in a restart are refused before anything is written
+	gotrue, _ := api.applications.Get("gotrue")
+	configPath := filepath.Join(t.TempDir(), "gotrue.env")
+	gotrue.Config = &registry.ConfigFile{Path: configPath, OldPath: configPath + ".old"}
+	for _, method := range []string{"POST", "PATCH"} {
+		body := `{"raw_contents": "GOTRUE_JWT_EXP=1\n", "restart_services": true}`
+		if method == "PATCH" {
+			body = `{"operations": [{"op": "set", "key": "GOTRUE_JWT_EXP", "value": "1"}], "restart_services": true}`
+		}
+		if response, body := configRequest(t, ts, method, "/config/gotrue/", body, nil); response.StatusCode != 409 {
+			t.Fatalf("expected the circuit breaker to refuse the config %s, got %d %s", method, response.StatusCode, body)
+		}
+		if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+			t.Fatalf("expected the refused %s not to write the config, got %v", method, err)
+		}
+	}
+
+	response, body = configRequest(t, ts, "GET", "/service/restart-limits", "", nil)
+	states := make([]restart_limits.UnitState, 0)
+	if err := json.Unmarshal([]byte(body), &states); err != nil || response.StatusCode != 200 || len(states) != 2 || states[0].Unit != "gotrue.service" || !states[0].BreakerOpen {
+		t.Fatalf("expected gotrue's breaker to be reported open, got %d %s", response.StatusCode, body)
+	}
+
+	delete(fake.Failures, "gotrue.service")
+	if response, body := configRequest(t, ts, "POST", "/service/restart-limits/gotrue/reset", "", nil); response.StatusCode != 200 {
+		t.Fatalf("expected the breaker to be reset, got %d %s", response.StatusCode, body)
+	}
+	response, body = configRequest(t, ts, "GET", "/service/restart/gotrue", "", nil)
+	if job := lifecycleJob(t, api, body); response.StatusCode != 202 || job.State != jobs.Succeeded {
+		t.Fatalf("expected restarts to be allowed after a reset, got %d %s", response.StatusCode, body)
+	}
+}
+
+func TestWaitForUnitActive(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "kong.service")
+	defer ts.Close()
+	api.unitSettlePeriod = 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := fake.Start(ctx, "kong.service"); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if err := api.waitForUnitActive(ctx, "kong.service"); err != nil || time.Since(started) < api.unitSettlePeriod {
+		t.Fatalf("expected the unit to be up once settled, got %v after %s", err, time.Since(started))
+	}
+
+	// systemd restarting a crashed service keeps it active, but bumps its restart counter
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fake.Update("kong.service", func(status *units.Status) { status.NRestarts++ })
+	}()
+	if err := api.waitForUnitActive(ctx, "kong.service"); !units.IsStartFailed(err) {
+		t.Fatalf("expected a unit restarted while settling to have failed, got %v", err)
+	}
+}
+
+func TestGuardedApplyRollback(t *testing.T) {
+	ts, api, fake := lifecycleTestServer(t, "gotrue.service")
+	defer ts.Close()
+	dir := t.TempDir()
+	app, _ := api.applications.Get("gotrue")
+	app.Config.Path, app.Config.OldPath = filepath.Join(dir, "gotrue.env"), filepath.Join(dir, "old.gotrue.env")
+	if err := os.WriteFile(app.Config.Path, []byte("GOTRUE_JWT_EXP=3600\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake.Failures["gotrue.service"] = &units.JobFailedError{Unit: "gotrue.service", Operation: "restart", Result: "failed"}
+
+	report, err := api.guardedApply(context.Background(), app, []byte("GOTRUE_JWT_EXP=60\n"), "test")
+	if err != nil || report.Outcome == Applied {
+		t.Fatalf("expected the apply to be rolled back, got %+v %v", report, err)
+	}
+	// the backup keeps the version from before the apply, not the one that failed
+	for _, path := range []string{app.Config.Path, app.Config.OldPath} {
+		if contents, _ := os.ReadFile(path); string(contents) != "GOTRUE_JWT_EXP=3600\n" {
+			t.Errorf("expected %s to hold the config from before the apply, got %q", path, contents)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package units
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Fake is an in-memory UnitManager, for testing without systemd
+type Fake struct {
+	mu      sync.Mutex
+	units   map[string]*Status
+	enabled map[string]bool
+	calls   []string
+	// Failures makes operations on a unit fail with the given error
+	Failures map[string]error
+}
+
+var _ UnitManager = (*Fake)(nil)
+var _ UnitManager = (*Systemd)(nil)
+
+// NewFake returns a Fake that knows the given units, all of them stopped
+func NewFake(units ...string) *Fake {
+	f := &Fake{
+		units:    make(map[string]*Status),
+		enabled:  make(map[string]bool),
+		Failures: make(map[string]error),
+	}
+	for _, unit := range units {
+		f.units[unit] = &Status{Unit: unit, LoadState: "loaded", ActiveState: "inactive", SubState: "dead"}
+	}
+	return f
+}
+
+// Enabled reports whether a unit has been enabled
+func (f *Fake) Enabled(unit string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled[unit]
+}
+
+func (f *Fake) run(ctx context.Context, operation string, unit string, fn func(status *Status)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("%s %s", operation, unit))
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s %s: %w", operation, unit, ErrTimeout)
+	}
+	status, ok := f.units[unit]
+	if !ok {
+		return fmt.Errorf("%s %s: %w", operation, unit, ErrUnitNotFound)
+	}
+	if err, ok := f.Failures[unit]; ok {
+		return err
+	}
+	fn(status)
+	return nil
+}
+
+func (f *Fake) Status(ctx context.Context, unit string) (*Status, error) {
+	var copied Status
+	err := f.run(ctx, "status", unit, func(status *Status) {
+		copied = *status
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+func (f *Fake) Start(ctx context.Context, unit string) error {
+	return f.run(ctx, "start", unit, func(status *Status) {
+		status.ActiveState, status.SubState = "active", "running"
+	})
+}
+
+func (f *Fake) Stop(ctx context.Context, unit string) error {
+	return f.run(ctx, "stop", unit, func(status *Status) {
+		status.ActiveState, status.SubState = "inactive", "dead"
+	})
+}
+
+func (f *Fake) Restart(ctx context.Context, unit string) error {
+	return f.run(ctx, "restart", unit, func(status *Status) {
+		status.ActiveState, status.SubState = "active", "running"
+	})
+}
+
+func (f *Fake) Reload(ctx context.Context, unit string) error {
+	return f.run(ctx, "reload", unit, func(*Status) {})
+}
+
+func (f *Fake) Enable(ctx context.Context, unit string) error {
+	return f.run(ctx, "enable", unit, func(*Status) {
+		f.enabled[unit] = true
+	})
+}
+
+func (f *Fake) Disable(ctx context.Context, unit string) error {
+	return f.run(ctx, "disable", unit, func(*Status) {
+		f.enabled[unit] = false
+	})
+}
+
+func (f *Fake) DaemonReload(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "daemon-reload")
+	return ctx.Err()
+}
+
+// Update changes the status of a unit, as systemd does on its own, e.g. when a service crashes
+func (f *Fake) Update(unit string, fn func(status *Status)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if status, ok := f.units[unit]; ok {
+		fn(status)
+	}
+}
+
+// CallLog returns the operations run so far, as "<operation> <unit>"
+func (f *Fake) CallLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
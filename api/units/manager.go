@@ -0,0 +1,145 @@
+package units
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-systemd/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// UnitManager controls systemd units; every operation waits for systemd to finish the job it
+// queued, or for ctx to be done
+type UnitManager interface {
+	Status(ctx context.Context, unit string) (*Status, error)
+	Start(ctx context.Context, unit string) error
+	Stop(ctx context.Context, unit string) error
+	Restart(ctx context.Context, unit string) error
+	Reload(ctx context.Context, unit string) error
+	Enable(ctx context.Context, unit string) error
+	Disable(ctx context.Context, unit string) error
+	// DaemonReload makes systemd re-read its unit files, like `systemctl daemon-reload`
+	DaemonReload(ctx context.Context) error
+}
+
+var ErrUnitNotFound = errors.New("unit not found")
+var ErrTimeout = errors.New("timed out waiting for systemd")
+
+// JobFailedError is returned when systemd ran a job for a unit but it didn't complete successfully,
+// e.g. because the service failed to start
+type JobFailedError struct {
+	Unit      string
+	Operation string
+	// Result is systemd's job result: failed, canceled, timeout, dependency, skipped...
+	Result string
+}
+
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s", e.Operation, e.Unit, e.Result)
+}
+
+// IsStartFailed reports whether err means a unit failed to (re)start
+func IsStartFailed(err error) bool {
+	var failed *JobFailedError
+	return errors.As(err, &failed) && failed.Operation != "stop"
+}
+
+const replaceMode = "replace"
+
+type jobFunc func(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+
+func (s *Systemd) runJob(ctx context.Context, operation string, unit string, queue func(*dbus.Conn) jobFunc) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan string, 1)
+	if _, err := queue(conn)(ctx, unit, replaceMode, done); err != nil {
+		return unitError(ctx, operation, unit, err)
+	}
+	select {
+	case result := <-done:
+		switch result {
+		case "done":
+			return nil
+		case "timeout":
+			return fmt.Errorf("%s %s: %w", operation, unit, ErrTimeout)
+		default:
+			return &JobFailedError{Unit: unit, Operation: operation, Result: result}
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("%s %s: %w", operation, unit, ErrTimeout)
+	}
+}
+
+// unitError maps the D-Bus errors systemd answers with to the typed errors of this package
+func unitError(ctx context.Context, operation string, unit string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s %s: %w", operation, unit, ErrTimeout)
+	}
+	var dbusErr godbus.Error
+	if errors.As(err, &dbusErr) && (dbusErr.Name == "org.freedesktop.systemd1.NoSuchUnit" || dbusErr.Name == "org.freedesktop.DBus.Error.FileNotFound") {
+		return fmt.Errorf("%s %s: %w", operation, unit, ErrUnitNotFound)
+	}
+	return fmt.Errorf("%s %s: %w", operation, unit, err)
+}
+
+func (s *Systemd) Start(ctx context.Context, unit string) error {
+	return s.runJob(ctx, "start", unit, func(conn *dbus.Conn) jobFunc { return conn.StartUnitContext })
+}
+
+func (s *Systemd) Stop(ctx context.Context, unit string) error {
+	return s.runJob(ctx, "stop", unit, func(conn *dbus.Conn) jobFunc { return conn.StopUnitContext })
+}
+
+func (s *Systemd) Restart(ctx context.Context, unit string) error {
+	return s.runJob(ctx, "restart", unit, func(conn *dbus.Conn) jobFunc { return conn.RestartUnitContext })
+}
+
+func (s *Systemd) Reload(ctx context.Context, unit string) error {
+	return s.runJob(ctx, "reload", unit, func(conn *dbus.Conn) jobFunc { return conn.ReloadUnitContext })
+}
+
+// Enable enables a unit's file and reloads systemd, as `systemctl enable` does
+func (s *Systemd) Enable(ctx context.Context, unit string) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{unit}, false, false); err != nil {
+		return unitError(ctx, "enable", unit, err)
+	}
+	return conn.ReloadContext(ctx)
+}
+
+// Disable disables a unit's file and reloads systemd, as `systemctl disable` does
+func (s *Systemd) Disable(ctx context.Context, unit string) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.DisableUnitFilesContext(ctx, []string{unit}, false); err != nil {
+		return unitError(ctx, "disable", unit, err)
+	}
+	return conn.ReloadContext(ctx)
+}
+
+func (s *Systemd) DaemonReload(ctx context.Context) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.ReloadContext(ctx); err != nil {
+		return unitError(ctx, "daemon-reload", "systemd", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package units
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/coreos/go-systemd/dbus"
+)
+
+// ExitStatus describes how the main process of a service last exited
+type ExitStatus struct {
+	// Code is how the process ended: exited, killed or dumped
+	Code      string     `json:"code"`
+	Status    int32      `json:"status"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// Status is the state of a systemd unit, as reported over D-Bus
+type Status struct {
+	Unit                 string      `json:"unit"`
+	LoadState            string      `json:"load_state"`
+	ActiveState          string      `json:"active_state"`
+	SubState             string      `json:"sub_state"`
+	MainPID              uint32      `json:"main_pid"`
+	ActiveEnterTimestamp *time.Time  `json:"active_enter_timestamp,omitempty"`
+	NRestarts            uint32      `json:"n_restarts"`
+	MemoryCurrent        *uint64     `json:"memory_current,omitempty"`
+	CPUUsageNSec         *uint64     `json:"cpu_usage_nsec,omitempty"`
+	Result               string      `json:"result,omitempty"`
+	LastExit             *ExitStatus `json:"last_exit,omitempty"`
+}
+
+// Systemd manages units through the systemd D-Bus API
+type Systemd struct{}
+
+func NewSystemd() *Systemd {
+	return &Systemd{}
+}
+
+func (s *Systemd) connect(ctx context.Context) (*dbus.Conn, error) {
+	return dbus.NewSystemConnectionContext(ctx)
+}
+
+// Status reads the current state of a unit
+func (s *Systemd) Status(ctx context.Context, unit string) (*Status, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	unitProperties, err := conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return nil, err
+	}
+	// only services have a main process; other units, like slices, simply don't report one
+	serviceProperties, err := conn.GetUnitTypePropertiesContext(ctx, unit, "Service")
+	if err != nil {
+		serviceProperties = map[string]interface{}{}
+	}
+	return statusFromProperties(unit, unitProperties, serviceProperties), nil
+}
+
+// statusFromProperties assembles a unit's status from its org.freedesktop.systemd1.Unit and
+// org.freedesktop.systemd1.Service properties
+func statusFromProperties(unit string, unitProperties map[string]interface{}, serviceProperties map[string]interface{}) *Status {
+	status := &Status{Unit: unit}
+	status.LoadState, _ = unitProperties["LoadState"].(string)
+	status.ActiveState, _ = unitProperties["ActiveState"].(string)
+	status.SubState, _ = unitProperties["SubState"].(string)
+	status.ActiveEnterTimestamp = timestamp(unitProperties["ActiveEnterTimestamp"])
+
+	status.MainPID, _ = serviceProperties["MainPID"].(uint32)
+	status.NRestarts, _ = serviceProperties["NRestarts"].(uint32)
+	status.MemoryCurrent = counter(serviceProperties["MemoryCurrent"])
+	status.CPUUsageNSec = counter(serviceProperties["CPUUsageNSec"])
+	status.Result, _ = serviceProperties["Result"].(string)
+
+	if code, ok := serviceProperties["ExecMainCode"].(int32); ok && code != 0 {
+		exitStatus, _ := serviceProperties["ExecMainStatus"].(int32)
+		status.LastExit = &ExitStatus{
+			Code:      exitCode(code),
+			Status:    exitStatus,
+			Timestamp: timestamp(serviceProperties["ExecMainExitTimestamp"]),
+		}
+	}
+	return status
+}
+
+// timestamp converts a systemd timestamp, in microseconds since the epoch, with 0 meaning never
+func timestamp(value interface{}) *time.Time {
+	usec, ok := value.(uint64)
+	if !ok || usec == 0 {
+		return nil
+	}
+	t := time.UnixMicro(int64(usec)).UTC()
+	return &t
+}
+
+// counter converts a resource counter, which systemd reports as the maximum uint64 when
+// accounting is disabled
+func counter(value interface{}) *uint64 {
+	v, ok := value.(uint64)
+	if !ok || v == math.MaxUint64 {
+		return nil
+	}
+	return &v
+}
+
+// exitCode names the si_code of the main process' exit, see waitid(2)
+func exitCode(code int32) string {
+	switch code {
+	case 1:
+		return "exited"
+	case 2:
+		return "killed"
+	case 3:
+		return "dumped"
+	}
+	return "unknown"
+}
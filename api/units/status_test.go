@@ -0,0 +1,39 @@
+package units
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStatusFromProperties(t *testing.T) {
+	started := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	status := statusFromProperties("postgrest.service", map[string]interface{}{
+		"LoadState":            "loaded",
+		"ActiveState":          "active",
+		"SubState":             "running",
+		"ActiveEnterTimestamp": uint64(started.UnixMicro()),
+	}, map[string]interface{}{
+		"MainPID":               uint32(4242),
+		"NRestarts":             uint32(3),
+		"MemoryCurrent":         uint64(1 << 20),
+		"CPUUsageNSec":          uint64(math.MaxUint64),
+		"Result":                "success",
+		"ExecMainCode":          int32(1),
+		"ExecMainStatus":        int32(137),
+		"ExecMainExitTimestamp": uint64(0),
+	})
+
+	if status.ActiveState != "active" || status.SubState != "running" || status.MainPID != 4242 || status.NRestarts != 3 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.ActiveEnterTimestamp == nil || !status.ActiveEnterTimestamp.Equal(started) {
+		t.Fatalf("unexpected active enter timestamp %v", status.ActiveEnterTimestamp)
+	}
+	if status.MemoryCurrent == nil || *status.MemoryCurrent != 1<<20 || status.CPUUsageNSec != nil {
+		t.Fatalf("expected memory to be reported and cpu usage to be missing, got %v %v", status.MemoryCurrent, status.CPUUsageNSec)
+	}
+	if status.LastExit == nil || status.LastExit.Code != "exited" || status.LastExit.Status != 137 || status.LastExit.Timestamp != nil {
+		t.Fatalf("unexpected last exit %+v", status.LastExit)
+	}
+}
@@ -1,13 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"os/exec"
 	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/jobs"
 )
 
 // FileContents holds the content of a config file
@@ -21,6 +25,7 @@ type BackupConfiguration struct {
 	BackupId  int `json:"backup_id"`
 }
 
+// BackupDatabase starts a WAL-G backup as a job
 func (a *API) BackupDatabase(w http.ResponseWriter, r *http.Request) error {
 	params := &BackupConfiguration{}
 
@@ -30,17 +35,25 @@ func (a *API) BackupDatabase(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 
-	cmd := exec.Command("sudo", "/root/commence_walg_backup.sh", strconv.Itoa(params.ProjectId), strconv.Itoa(params.BackupId))
-	output, err := cmd.Output()
+	job, err := a.jobs.Submit(backupJobSpec(params, getSubject(r)))
 	if err != nil {
-		errMessage := "failed to execute WAL-G backup"
-		logrus.WithField("output", string(output)).Warn(errMessage)
-		return errors.Wrap(err, errMessage)
+		return errors.Wrap(err, "failed to start WAL-G backup")
 	}
-	logrus.WithField("output", string(output)).Info("WAL-G backup completed")
-	return nil
+	return sendJSON(w, http.StatusAccepted, job)
 }
 
+func backupJobSpec(params *BackupConfiguration, author string) jobs.Spec {
+	return jobs.Spec{
+		Kind:        "walg-backup",
+		Description: fmt.Sprintf("WAL-G backup %d of project %d", params.BackupId, params.ProjectId),
+		Author:      author,
+		Run: func(ctx context.Context, output io.Writer) error {
+			return jobs.RunCommand(ctx, output, "sudo", "/root/commence_walg_backup.sh", strconv.Itoa(params.ProjectId), strconv.Itoa(params.BackupId))
+		},
+	}
+}
+
+// RestoreDatabase starts a WAL-G restore as a job
 func (a *API) RestoreDatabase(w http.ResponseWriter, r *http.Request) error {
 	params := &RestoreConfiguration{}
 
@@ -50,15 +63,18 @@ func (a *API) RestoreDatabase(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(w, http.StatusInternalServerError, err.Error())
 	}
 
-	cmd := exec.Command("sudo", "/root/commence_walg_restore.sh", params.BackupName, params.RecoveryTimeTarget)
-	output, err := cmd.Output()
+	job, err := a.jobs.Submit(jobs.Spec{
+		Kind:        "walg-restore",
+		Description: fmt.Sprintf("WAL-G restore of %s", params.BackupName),
+		Author:      getSubject(r),
+		Run: func(ctx context.Context, output io.Writer) error {
+			return jobs.RunCommand(ctx, output, "sudo", "/root/commence_walg_restore.sh", params.BackupName, params.RecoveryTimeTarget)
+		},
+	})
 	if err != nil {
-		errMessage := "failed to execute WAL-G restore"
-		logrus.WithField("output", string(output)).Warn(errMessage)
-		return errors.Wrap(err, errMessage)
+		return errors.Wrap(err, "failed to start WAL-G restore")
 	}
-	logrus.WithField("output", string(output)).Info("WAL-G restore completed")
-	return nil
+	return sendJSON(w, http.StatusAccepted, job)
 }
 
 func (a *API) CompleteRestorationWALG(w http.ResponseWriter, r *http.Request) error {
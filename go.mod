@@ -37,13 +37,14 @@ require (
 	go.uber.org/multierr v1.7.0 // indirect
 	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-	golang.org/x/sys v0.0.0-20220328115105-d36c6a25d886 // indirect
+	golang.org/x/sys v0.0.0-20220328115105-d36c6a25d886
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require (
 	github.com/Sean-Der/fail2go v0.0.0-20170425205434-72ede0333ad6
+	github.com/pmezard/go-difflib v1.0.0
 	golang.org/x/exp v0.0.0-20220713135740-79cabaa25d75
 )
 
@@ -64,7 +65,7 @@ require (
 	github.com/go-kit/log v0.2.0 // indirect
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
 	github.com/godbus/dbus v0.0.0-20190402143921-271e53dc4968 // indirect
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
+	github.com/godbus/dbus/v5 v5.0.4
 	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/hodgesds/perf-utils v0.2.5 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
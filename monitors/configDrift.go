@@ -0,0 +1,231 @@
+package monitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/config_history"
+)
+
+type ConfigDriftMonitorConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	IntervalDuration string `yaml:"interval_duration"`
+	// EventWebhook is sent a ConfigDriftEvent as JSON whenever drift appears
+	EventWebhook string `yaml:"event_webhook"`
+}
+
+const DefaultConfigDriftMonitoringIntervalDuration = "1m"
+
+// ManagedConfig is a config file whose contents are expected to match the version last written
+// through the admin API
+type ManagedConfig struct {
+	Application string
+	Path        string
+}
+
+// ConfigDrift is the state of a single managed config file as of the last check
+type ConfigDrift struct {
+	Application string `json:"application"`
+	Path        string `json:"path"`
+	// Tracked is false when the file has never existed, so there's nothing to compare against
+	Tracked      bool      `json:"tracked"`
+	Drifted      bool      `json:"drifted"`
+	Missing      bool      `json:"missing"`
+	Revision     int       `json:"revision,omitempty"`
+	ExpectedHash string    `json:"expected_hash,omitempty"`
+	ActualHash   string    `json:"actual_hash,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// ConfigDriftEvent is emitted when a managed config file stops matching its last written version
+type ConfigDriftEvent struct {
+	Event string `json:"event"`
+	ConfigDrift
+}
+
+// ConfigDriftMonitor periodically rehashes every managed config file and compares it against the
+// latest revision in the config history, which holds every version written through the admin API
+type ConfigDriftMonitor struct {
+	enabled      bool
+	interval     time.Duration
+	eventWebhook string
+	doneChan     chan (bool)
+	configs      []ManagedConfig
+	history      *config_history.Store
+	// lock is held while a file is compared, so writes through the API are never seen half done
+	lock func(application string) func()
+
+	checkMu    sync.Mutex
+	mu         sync.Mutex
+	state      map[string]ConfigDrift
+	gauge      *prometheus.Desc
+	httpClient *http.Client
+}
+
+func NewConfigDriftMonitor(config ConfigDriftMonitorConfig, configs []ManagedConfig, history *config_history.Store, lock func(application string) func()) (*ConfigDriftMonitor, error) {
+	if config.IntervalDuration == "" {
+		config.IntervalDuration = DefaultConfigDriftMonitoringIntervalDuration
+	}
+
+	monitorDuration, err := time.ParseDuration(config.IntervalDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigDriftMonitor{
+		enabled:      config.Enabled,
+		interval:     monitorDuration,
+		eventWebhook: config.EventWebhook,
+		doneChan:     make(chan bool, 1),
+		configs:      configs,
+		history:      history,
+		lock:         lock,
+		state:        make(map[string]ConfigDrift),
+		gauge: prometheus.NewDesc(
+			"adminapi_config_drift",
+			"Whether a managed config file differs from the version last written through the admin API",
+			[]string{"application"}, nil,
+		),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (d *ConfigDriftMonitor) IsEnabled() bool {
+	return d.enabled
+}
+
+func (d *ConfigDriftMonitor) StartMonitoring() {
+	if !d.IsEnabled() {
+		return
+	}
+
+	logrus.WithField("monitor", "config drift").Infof("Starting config drift monitor for %d files.", len(d.configs))
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+
+	if _, err := d.Check(); err != nil {
+		logrus.WithField("monitor", "config drift").WithError(err).Error("Failed checking config drift.")
+	}
+	for {
+		select {
+		case <-d.doneChan:
+			logrus.WithField("monitor", "config drift").Info("Received stop signal. Stopping config drift monitor.")
+			return
+		case <-t.C:
+			if _, err := d.Check(); err != nil {
+				logrus.WithField("monitor", "config drift").WithError(err).Error("Failed checking config drift.")
+			}
+		}
+	}
+}
+
+func (d *ConfigDriftMonitor) StopMonitoring() {
+	if !d.IsEnabled() {
+		return
+	}
+	d.doneChan <- true
+}
+
+// Check rehashes every managed config file, returning their state; a file with no history yet is
+// recorded as its own baseline, so hand edits are noticed from then on
+func (d *ConfigDriftMonitor) Check() ([]ConfigDrift, error) {
+	d.checkMu.Lock()
+	defer d.checkMu.Unlock()
+
+	report := make([]ConfigDrift, 0, len(d.configs))
+	var checkErr error
+	for _, config := range d.configs {
+		drift, err := d.check(config)
+		if err != nil {
+			checkErr = errors.Wrapf(err, "couldn't check %s for drift", config.Application)
+			continue
+		}
+		report = append(report, drift)
+
+		d.mu.Lock()
+		previous, seen := d.state[config.Application]
+		d.state[config.Application] = drift
+		d.mu.Unlock()
+
+		if drift.Drifted && (!seen || !previous.Drifted || previous.ActualHash != drift.ActualHash) {
+			d.emit(drift)
+		}
+	}
+	return report, checkErr
+}
+
+func (d *ConfigDriftMonitor) check(config ManagedConfig) (ConfigDrift, error) {
+	unlock := d.lock(config.Application)
+	defer unlock()
+
+	drift := ConfigDrift{Application: config.Application, Path: config.Path, CheckedAt: time.Now().UTC()}
+	latest, err := d.history.RecordBaseline(config.Application, config.Path)
+	if err != nil || latest == nil {
+		return drift, err
+	}
+	drift.Tracked = true
+	drift.Revision = latest.Rev
+	drift.ExpectedHash = latest.Hash
+
+	contents, err := os.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		drift.Missing = true
+		drift.Drifted = true
+		return drift, nil
+	}
+	if err != nil {
+		return drift, err
+	}
+	drift.ActualHash = config_history.Hash(contents)
+	drift.Drifted = drift.ActualHash != drift.ExpectedHash
+	return drift, nil
+}
+
+func (d *ConfigDriftMonitor) emit(drift ConfigDrift) {
+	log := logrus.WithField("monitor", "config drift").WithField("application", drift.Application)
+	log.Warnf("%s no longer matches revision %d written through the admin API", drift.Path, drift.Revision)
+	if d.eventWebhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(&ConfigDriftEvent{Event: "config_drift", ConfigDrift: drift})
+	if err != nil {
+		log.WithError(err).Error("Failed to serialize config drift event.")
+		return
+	}
+	go func() {
+		resp, err := d.httpClient.Post(d.eventWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.WithError(err).Error("Failed to send config drift event.")
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.WithError(fmt.Errorf("unexpected status %d", resp.StatusCode)).Error("Failed to send config drift event.")
+		}
+	}()
+}
+
+func (d *ConfigDriftMonitor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.gauge
+}
+
+func (d *ConfigDriftMonitor) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for application, drift := range d.state {
+		value := 0.0
+		if drift.Drifted {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(d.gauge, prometheus.GaugeValue, value, application)
+	}
+}
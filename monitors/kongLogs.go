@@ -0,0 +1,249 @@
+package monitors
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/supabase-admin-api/api/kong_logs"
+)
+
+type KongLogMonitorConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	IntervalDuration string `yaml:"interval_duration"`
+	AccessLog        string `yaml:"access_log"`
+	ErrorLog         string `yaml:"error_log"`
+	// RecentErrors is how many upstream errors are kept for /kong/errors
+	RecentErrors int `yaml:"recent_errors"`
+}
+
+const DefaultKongLogMonitoringIntervalDuration = "5s"
+const DefaultKongAccessLog = "/usr/local/kong/logs/access.log"
+const DefaultKongErrorLog = "/usr/local/kong/logs/error.log"
+const DefaultKongRecentErrors = 200
+
+// KongLogMonitor tails Kong's access and error logs, counting requests and upstream errors per
+// route and service of Kong's declarative config, and keeping the latest upstream errors
+type KongLogMonitor struct {
+	enabled    bool
+	interval   time.Duration
+	doneChan   chan (bool)
+	configPath string
+	access     *kong_logs.Tailer
+	errorLog   *kong_logs.Tailer
+
+	pollMu        sync.Mutex
+	routes        *kong_logs.Routes
+	routesModTime time.Time
+
+	mu           sync.Mutex
+	recent       []kong_logs.ErrorEntry
+	recentLimit  int
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	upstream     *prometheus.HistogramVec
+	errors       *prometheus.CounterVec
+	parseFailure *prometheus.CounterVec
+}
+
+func NewKongLogMonitor(config KongLogMonitorConfig, kongConfigPath string) (*KongLogMonitor, error) {
+	if config.IntervalDuration == "" {
+		config.IntervalDuration = DefaultKongLogMonitoringIntervalDuration
+	}
+	if config.AccessLog == "" {
+		config.AccessLog = DefaultKongAccessLog
+	}
+	if config.ErrorLog == "" {
+		config.ErrorLog = DefaultKongErrorLog
+	}
+	if config.RecentErrors == 0 {
+		config.RecentErrors = DefaultKongRecentErrors
+	}
+
+	monitorDuration, err := time.ParseDuration(config.IntervalDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &KongLogMonitor{
+		enabled:     config.Enabled,
+		interval:    monitorDuration,
+		doneChan:    make(chan bool, 1),
+		configPath:  kongConfigPath,
+		recent:      make([]kong_logs.ErrorEntry, 0),
+		recentLimit: config.RecentErrors,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "adminapi_kong_requests_total",
+			Help: "Requests served by Kong, by route, service and status class",
+		}, []string{"route", "service", "status_class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "adminapi_kong_request_duration_seconds",
+			Help:    "Time Kong took to serve requests, when its log format includes $request_time",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "service"}),
+		upstream: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "adminapi_kong_upstream_duration_seconds",
+			Help:    "Time upstreams took to respond, when Kong's log format includes $upstream_response_time",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "service"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "adminapi_kong_upstream_errors_total",
+			Help: "Upstream errors in Kong's error log, by route and service",
+		}, []string{"route", "service"}),
+		parseFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "adminapi_kong_log_parse_failures_total",
+			Help: "Lines of Kong's logs that couldn't be parsed",
+		}, []string{"log"}),
+	}
+	if m.enabled {
+		m.access = kong_logs.NewTailer(config.AccessLog)
+		m.errorLog = kong_logs.NewTailer(config.ErrorLog)
+	}
+	return m, nil
+}
+
+func (m *KongLogMonitor) IsEnabled() bool {
+	return m.enabled
+}
+
+func (m *KongLogMonitor) StartMonitoring() {
+	if !m.IsEnabled() {
+		return
+	}
+
+	logrus.WithField("monitor", "kong logs").Info("Starting kong log monitor.")
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.doneChan:
+			logrus.WithField("monitor", "kong logs").Info("Received stop signal. Stopping kong log monitor.")
+			m.access.Close()
+			m.errorLog.Close()
+			return
+		case <-t.C:
+			m.Poll()
+		}
+	}
+}
+
+func (m *KongLogMonitor) StopMonitoring() {
+	if !m.IsEnabled() {
+		return
+	}
+	m.doneChan <- true
+}
+
+// Poll processes the lines appended to both logs since the last poll
+func (m *KongLogMonitor) Poll() {
+	if !m.IsEnabled() {
+		return
+	}
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+	log := logrus.WithField("monitor", "kong logs")
+	m.loadRoutes()
+
+	lines, err := m.access.Poll()
+	if err != nil {
+		log.WithError(err).Warn("Failed reading kong access log.")
+	}
+	for _, line := range lines {
+		m.recordAccess(line)
+	}
+
+	lines, err = m.errorLog.Poll()
+	if err != nil {
+		log.WithError(err).Warn("Failed reading kong error log.")
+	}
+	for _, line := range lines {
+		m.recordError(line)
+	}
+}
+
+// loadRoutes rereads the routes of the declarative config whenever it changes
+func (m *KongLogMonitor) loadRoutes() {
+	info, err := os.Stat(m.configPath)
+	if err != nil || info.ModTime().Equal(m.routesModTime) {
+		return
+	}
+	contents, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return
+	}
+	routes, err := kong_logs.LoadRoutes(contents)
+	if err != nil {
+		logrus.WithField("monitor", "kong logs").WithError(err).Warn("Failed reading kong routes.")
+		return
+	}
+	m.routes, m.routesModTime = routes, info.ModTime()
+}
+
+func (m *KongLogMonitor) recordAccess(line string) {
+	entry, err := kong_logs.ParseAccess(line)
+	if err != nil {
+		m.parseFailure.WithLabelValues("access").Inc()
+		return
+	}
+	route, service := m.routes.Resolve(entry.Path)
+	m.requests.WithLabelValues(route, service, entry.StatusClass()).Inc()
+	if entry.RequestTime != nil {
+		m.duration.WithLabelValues(route, service).Observe(*entry.RequestTime)
+	}
+	if entry.UpstreamTime != nil {
+		m.upstream.WithLabelValues(route, service).Observe(*entry.UpstreamTime)
+	}
+}
+
+func (m *KongLogMonitor) recordError(line string) {
+	entry, err := kong_logs.ParseError(line)
+	if err != nil {
+		m.parseFailure.WithLabelValues("error").Inc()
+		return
+	}
+	if !entry.IsUpstream() {
+		return
+	}
+	entry.Route, entry.Service = m.routes.Resolve(entry.Path())
+	m.errors.WithLabelValues(entry.Route, entry.Service).Inc()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recent = append(m.recent, *entry)
+	if len(m.recent) > m.recentLimit {
+		m.recent = m.recent[len(m.recent)-m.recentLimit:]
+	}
+}
+
+// RecentErrors returns the latest upstream errors since a time, newest first
+func (m *KongLogMonitor) RecentErrors(since time.Time, limit int) []kong_logs.ErrorEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	errors := make([]kong_logs.ErrorEntry, 0)
+	for i := len(m.recent) - 1; i >= 0 && len(errors) < limit; i-- {
+		if m.recent[i].Time.Before(since) {
+			break
+		}
+		errors = append(errors, m.recent[i])
+	}
+	return errors
+}
+
+func (m *KongLogMonitor) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+	m.upstream.Describe(ch)
+	m.errors.Describe(ch)
+	m.parseFailure.Describe(ch)
+}
+
+func (m *KongLogMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.upstream.Collect(ch)
+	m.errors.Collect(ch)
+	m.parseFailure.Collect(ch)
+}
@@ -0,0 +1,123 @@
+package monitors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const kongFixtureConfig = `
+_format_version: "1.1"
+services:
+  - name: auth-v1
+    url: http://localhost:9999/
+    routes:
+      - name: auth-v1-all
+        paths: [/auth/v1/]
+  - name: rest-v1
+    url: http://localhost:3000/
+    routes:
+      - paths: [/rest/v1/]
+`
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func histogramOf(t *testing.T, histograms *prometheus.HistogramVec, labels ...string) *dto.Histogram {
+	metric := &dto.Metric{}
+	if err := histograms.WithLabelValues(labels...).(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram()
+}
+
+func TestKongLogMonitor(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "kong.yml")
+	accessLog := filepath.Join(dir, "access.log")
+	errorLog := filepath.Join(dir, "error.log")
+	for path, contents := range map[string]string{configPath: kongFixtureConfig, accessLog: "", errorLog: ""} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	monitor, err := NewKongLogMonitor(KongLogMonitorConfig{Enabled: true, AccessLog: accessLog, ErrorLog: errorLog}, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.access.Close()
+	defer monitor.errorLog.Close()
+
+	appendLines(t, accessLog,
+		`10.0.0.1 - - [01/Jun/2022:10:00:00 +0000] "GET /auth/v1/user?apikey=x HTTP/1.1" 200 12 "-" "curl/7.68.0" 0.012 0.010`,
+		`10.0.0.1 - - [01/Jun/2022:10:00:01 +0000] "POST /auth/v1/token HTTP/1.1" 200 512 "-" "curl/7.68.0" 0.250 0.240`,
+		`10.0.0.2 - - [01/Jun/2022:10:00:02 +0000] "GET /rest/v1/todos HTTP/1.1" 502 0 "-" "curl/7.68.0" 1.500 -`,
+		`10.0.0.2 - - [01/Jun/2022:10:00:03 +0000] "GET /storage/v1/object HTTP/1.1" 404 0 "-" "curl/7.68.0"`,
+		`not an access log line`,
+	)
+	appendLines(t, errorLog,
+		`2022/06/01 10:00:02 [error] 7#0: *3 connect() failed (111: Connection refused) while connecting to upstream, client: 10.0.0.2, server: kong, request: "GET /rest/v1/todos HTTP/1.1", upstream: "http://127.0.0.1:3000/todos", host: "localhost"`,
+		`2022/06/01 10:00:04 [notice] 1#0: signal process started`,
+	)
+	monitor.Poll()
+
+	for _, c := range []struct {
+		labels   []string
+		expected float64
+	}{
+		{[]string{"auth-v1-all", "auth-v1", "2xx"}, 2},
+		{[]string{"/rest/v1/", "rest-v1", "5xx"}, 1},
+		{[]string{"unmatched", "unmatched", "4xx"}, 1},
+	} {
+		if got := testutil.ToFloat64(monitor.requests.WithLabelValues(c.labels...)); got != c.expected {
+			t.Errorf("expected %v requests for %v, got %v", c.expected, c.labels, got)
+		}
+	}
+	if got := testutil.ToFloat64(monitor.parseFailure.WithLabelValues("access")); got != 1 {
+		t.Errorf("expected a parse failure of the access log, got %v", got)
+	}
+
+	duration := histogramOf(t, monitor.duration, "auth-v1-all", "auth-v1")
+	if duration.GetSampleCount() != 2 || duration.GetSampleSum() != 0.262 {
+		t.Errorf("expected two request durations of the auth route, got %d summing to %v", duration.GetSampleCount(), duration.GetSampleSum())
+	}
+	if upstream := histogramOf(t, monitor.upstream, "/rest/v1/", "rest-v1"); upstream.GetSampleCount() != 0 {
+		t.Errorf("expected no upstream time without a response, got %d", upstream.GetSampleCount())
+	}
+	if upstream := histogramOf(t, monitor.upstream, "auth-v1-all", "auth-v1"); upstream.GetSampleCount() != 2 {
+		t.Errorf("expected two upstream durations of the auth route, got %d", upstream.GetSampleCount())
+	}
+
+	if got := testutil.ToFloat64(monitor.errors.WithLabelValues("/rest/v1/", "rest-v1")); got != 1 {
+		t.Errorf("expected an upstream error of the rest route, got %v", got)
+	}
+	errors := monitor.RecentErrors(time.Time{}, 10)
+	if len(errors) != 1 || errors[0].Route != "/rest/v1/" || errors[0].Upstream != "http://127.0.0.1:3000/todos" {
+		t.Fatalf("expected the upstream error to be kept, got %+v", errors)
+	}
+
+	// only lines appended since the last poll are counted
+	appendLines(t, accessLog, `10.0.0.1 - - [01/Jun/2022:10:00:05 +0000] "GET /auth/v1/user HTTP/1.1" 401 0 "-" "curl/7.68.0" 0.002 0.001`)
+	monitor.Poll()
+	if got := testutil.ToFloat64(monitor.requests.WithLabelValues("auth-v1-all", "auth-v1", "2xx")); got != 2 {
+		t.Errorf("expected earlier lines not to be counted again, got %v", got)
+	}
+	if got := testutil.ToFloat64(monitor.requests.WithLabelValues("auth-v1-all", "auth-v1", "4xx")); got != 1 {
+		t.Errorf("expected the new line to be counted, got %v", got)
+	}
+}
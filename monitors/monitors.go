@@ -1,28 +1,58 @@
 package monitors
 
+import (
+	"github.com/supabase/supabase-admin-api/api/config_history"
+)
+
 type MonitoringConfig struct {
-	DiskUsage DiskUsageMonitorConfig `yaml:"disk_usage"`
+	DiskUsage   DiskUsageMonitorConfig   `yaml:"disk_usage"`
+	ConfigDrift ConfigDriftMonitorConfig `yaml:"config_drift"`
+	KongLogs    KongLogMonitorConfig     `yaml:"kong_logs"`
 }
 
 type MonitorSet struct {
-	diskUsage *DiskUsageMonitor
+	diskUsage   *DiskUsageMonitor
+	configDrift *ConfigDriftMonitor
+	kongLogs    *KongLogMonitor
 }
 
-func NewMonitorSet(config MonitoringConfig) (*MonitorSet, error) {
+func NewMonitorSet(config MonitoringConfig, managedConfigs []ManagedConfig, history *config_history.Store, lockConfig func(application string) func(), kongConfigPath string) (*MonitorSet, error) {
 	diskUsageMonitor, err := NewDiskUsageMonitor(config.DiskUsage)
 	if err != nil {
 		return nil, err
 	}
+	configDriftMonitor, err := NewConfigDriftMonitor(config.ConfigDrift, managedConfigs, history, lockConfig)
+	if err != nil {
+		return nil, err
+	}
+	kongLogMonitor, err := NewKongLogMonitor(config.KongLogs, kongConfigPath)
+	if err != nil {
+		return nil, err
+	}
 
 	return &MonitorSet{
-		diskUsage: diskUsageMonitor,
+		diskUsage:   diskUsageMonitor,
+		configDrift: configDriftMonitor,
+		kongLogs:    kongLogMonitor,
 	}, nil
 }
 
+func (m *MonitorSet) ConfigDrift() *ConfigDriftMonitor {
+	return m.configDrift
+}
+
+func (m *MonitorSet) KongLogs() *KongLogMonitor {
+	return m.kongLogs
+}
+
 func (m *MonitorSet) StartMonitoring() {
 	go m.diskUsage.StartMonitoring()
+	go m.configDrift.StartMonitoring()
+	go m.kongLogs.StartMonitoring()
 }
 
 func (m *MonitorSet) StopMonitoring() {
 	m.diskUsage.StopMonitoring()
+	m.configDrift.StopMonitoring()
+	m.kongLogs.StopMonitoring()
 }